@@ -0,0 +1,437 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
+)
+
+// Decisions recorded in Entry.Decision
+const (
+	Allowed  = "allowed"
+	Rejected = "rejected"
+)
+
+// genesis is the prev hash of the first entry
+const genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// hashSuffix matches the hash field appended to every line
+var hashSuffix = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// Entry is one audit record. Every line of the journal is an Entry in JSON;
+// hash is the SHA-256 of the line without the hash field, and prev is the
+// hash of the line before, so editing, inserting or removing a line breaks
+// the chain.
+type Entry struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	SessionID  string    `json:"sessionId,omitempty"`
+	User       string    `json:"user,omitempty"`    // Navigator user, if the command names one
+	Type       string    `json:"type"`              // Command type, e.g. execute_command, read_log, shutdown
+	Command    string    `json:"command,omitempty"` // Executed command or cancelled session
+	Args       []string  `json:"args,omitempty"`
+	Path       string    `json:"path,omitempty"`   // Resolved binary or log file
+	Decision   string    `json:"decision"`         // Allowed or Rejected
+	Rule       string    `json:"rule,omitempty"`   // Policy rule that decided
+	Reason     string    `json:"reason,omitempty"` // Why it was rejected or failed
+	Status     string    `json:"status,omitempty"` // completed, timeout, cancelled, failed, explain
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"` // Payload sent to the Navigator
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Prev       string    `json:"prev"`
+	Hash       string    `json:"hash,omitempty"`
+}
+
+// Head identifies the latest entry, so the Navigator can anchor the chain
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Filter selects entries in Query. Zero values match everything.
+type Filter struct {
+	SessionID string
+	Type      string
+	Since     time.Time
+	Until     time.Time
+	Before    uint64 // Only entries with a lower sequence number, 0 = no bound
+	Limit     int    // Return the last Limit matches, 0 = all
+}
+
+// Journal is an append-only, hash-chained audit log in JSON lines. A nil
+// *Journal is valid and records nothing.
+//
+// When the file reaches MaxSize it is renamed to <path>.<last seq> and a new
+// one is started. The chain continues across files, the first entry of the
+// new file points to the last one of the old.
+type Journal struct {
+	MaxSize  int64 // Bytes per file before rotation, 0 = never rotate
+	MaxFiles int   // Rotated files to keep, 0 = all
+	path     string
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	file *os.File
+	size int64 // Bytes in file
+	head Head
+}
+
+// Open opens or creates the journal at path
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	j := &Journal{
+		path:   path,
+		logger: logging.For("audit"),
+		head:   Head{Hash: genesis},
+	}
+	if err := j.create(); err != nil {
+		return nil, err
+	}
+
+	// Continue the chain after the last readable entry. A damaged journal
+	// must not switch auditing off, Verify reports the damage.
+	snap, err := j.snapshot()
+	if err != nil {
+		j.file.Close()
+		return nil, err
+	}
+	defer snap.close()
+	err = j.scan(snap, true, func(e Entry, line []byte) error {
+		j.head = Head{Seq: e.Seq, Hash: e.Hash}
+		return nil
+	})
+	if err != nil {
+		j.file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// create opens the file at path for appending, creating it if needed
+func (j *Journal) create() error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit journal: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open audit journal: %w", err)
+	}
+	j.file = f
+	j.size = info.Size()
+	return nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Record appends an entry. Seq, Time, Prev and Hash are filled in.
+// Failures are logged, auditing never blocks a command.
+func (j *Journal) Record(e Entry) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	e.Seq = j.head.Seq + 1
+	e.Time = time.Now().UTC()
+	e.Prev = j.head.Hash
+	e.Hash = ""
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		j.logger.Error("Failed to encode audit entry", "error", err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	// Append the hash as last field: {...,"hash":"<hex>"}
+	line := append(body[:len(body)-1], fmt.Sprintf(`,"hash":"%s"}`+"\n", hash)...)
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		j.logger.Error("Failed to write audit entry", "error", err, "type", e.Type, "session", e.SessionID)
+		return
+	}
+	if err := j.file.Sync(); err != nil {
+		j.logger.Warn("Failed to sync audit journal", "error", err)
+	}
+
+	j.head = Head{Seq: e.Seq, Hash: hash}
+	if j.MaxSize > 0 && j.size >= j.MaxSize {
+		j.rotate()
+	}
+}
+
+// rotate renames the full file after its last entry and starts a new one.
+// Called with j.mu held.
+func (j *Journal) rotate() {
+	rotated := fmt.Sprintf("%s.%d", j.path, j.head.Seq)
+	if err := os.Rename(j.path, rotated); err != nil {
+		j.logger.Error("Failed to rotate audit journal", "error", err)
+		return
+	}
+	j.file.Close()
+	if err := j.create(); err != nil {
+		// Keep appending to the renamed file rather than losing entries
+		j.logger.Error("Failed to start new audit journal file", "error", err)
+		if f, err := os.OpenFile(rotated, os.O_WRONLY|os.O_APPEND, 0600); err == nil {
+			j.file = f
+		}
+		return
+	}
+	j.logger.Info("Audit journal rotated", "file", rotated, "seq", j.head.Seq)
+
+	if j.MaxFiles <= 0 {
+		return
+	}
+	names, err := j.rotated()
+	if err != nil {
+		j.logger.Warn("Failed to list rotated audit files", "error", err)
+		return
+	}
+	for _, name := range names[:max(len(names)-j.MaxFiles, 0)] {
+		if err := os.Remove(name); err != nil {
+			j.logger.Warn("Failed to remove rotated audit file", "file", name, "error", err)
+		}
+	}
+}
+
+// rotated returns the rotated files of the journal, oldest first
+func (j *Journal) rotated() ([]string, error) {
+	dir, base := filepath.Split(j.path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		name string
+		seq  uint64
+	}
+	var files []file
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		seq, err := strconv.ParseUint(suffix, 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, file{filepath.Join(dir, entry.Name()), seq})
+	}
+	slices.SortFunc(files, func(a, b file) int { return cmp.Compare(a.seq, b.seq) })
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.name
+	}
+	return names, nil
+}
+
+// snapshot is the journal as of one moment. Its files stay readable
+// through later rotations, and entries appended afterwards are past size.
+type snapshot struct {
+	files   []*os.File // Rotated files oldest first, then the current one
+	size    int64      // Bytes of the current file that belong to it
+	head    Head
+	rotated bool // The oldest file is a rotated one, earlier files may be gone
+}
+
+// snapshot opens the journal's files for reading. Only this takes the lock,
+// scanning them doesn't hold up Record.
+func (j *Journal) snapshot() (*snapshot, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	names, err := j.rotated()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit journal: %w", err)
+	}
+	s := &snapshot{size: j.size, head: j.head, rotated: len(names) > 0}
+	for _, name := range append(names, j.path) {
+		f, err := os.Open(name)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to read audit journal: %w", err)
+		}
+		s.files = append(s.files, f)
+	}
+	return s, nil
+}
+
+// close closes the snapshot's files
+func (s *snapshot) close() {
+	for _, f := range s.files {
+		f.Close()
+	}
+}
+
+// Head returns the latest entry's sequence number and hash
+func (j *Journal) Head() Head {
+	if j == nil {
+		return Head{}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.head
+}
+
+// Verify checks the hash chain up to the current head and returns the
+// number of entries. If rotated files were removed, the chain starts at
+// the oldest one left.
+func (j *Journal) Verify() (uint64, error) {
+	if j == nil {
+		return 0, nil
+	}
+	snap, err := j.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.close()
+
+	prev := genesis
+	var count, next uint64 = 0, 1
+	err = j.scan(snap, false, func(e Entry, line []byte) error {
+		if count == 0 && snap.rotated && e.Seq > 1 {
+			prev, next = e.Prev, e.Seq
+		}
+		m := hashSuffix.FindSubmatchIndex(line)
+		if m == nil {
+			return fmt.Errorf("entry %d has no hash", e.Seq)
+		}
+		body := append(append([]byte(nil), line[:m[0]]...), '}')
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != e.Hash {
+			return fmt.Errorf("entry %d was modified", e.Seq)
+		}
+		if e.Prev != prev {
+			return fmt.Errorf("chain broken before entry %d", e.Seq)
+		}
+		if e.Seq != next {
+			return fmt.Errorf("entry %d out of sequence, expected %d", e.Seq, next)
+		}
+		prev = e.Hash
+		count++
+		next++
+		return nil
+	})
+	if err == nil && prev != snap.head.Hash {
+		err = fmt.Errorf("journal ends before entry %d", snap.head.Seq)
+	}
+	return count, err
+}
+
+// Query returns the entries matching f, oldest first
+func (j *Journal) Query(f Filter) ([]Entry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	snap, err := j.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.close()
+
+	var entries []Entry
+	err = j.scan(snap, true, func(e Entry, line []byte) error {
+		if f.SessionID != "" && e.SessionID != f.SessionID {
+			return nil
+		}
+		if f.Type != "" && e.Type != f.Type {
+			return nil
+		}
+		if f.Before != 0 && e.Seq >= f.Before {
+			return nil
+		}
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			return nil
+		}
+		if !f.Until.IsZero() && e.Time.After(f.Until) {
+			return nil
+		}
+		entries = append(entries, e)
+		if f.Limit > 0 && len(entries) > 2*f.Limit {
+			// Keep memory bounded, only the last Limit are returned
+			entries = append(entries[:0], entries[len(entries)-f.Limit:]...)
+		}
+		return nil
+	})
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	return entries, err
+}
+
+// scan calls fn for every entry in the snapshot, oldest first. With
+// skipCorrupt, lines that are not valid JSON are logged and skipped instead
+// of ending the scan.
+func (j *Journal) scan(s *snapshot, skipCorrupt bool, fn func(e Entry, line []byte) error) error {
+	for i, f := range s.files {
+		var r io.Reader = f
+		if i == len(s.files)-1 {
+			r = io.LimitReader(f, s.size)
+		}
+		if err := j.scanFile(f.Name(), r, skipCorrupt, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanFile calls fn for every entry read from r
+func (j *Journal) scanFile(name string, r io.Reader, skipCorrupt bool, fn func(e Entry, line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if skipCorrupt {
+				j.logger.Error("Skipping corrupt audit journal line", "file", name, "line", n, "error", err)
+				continue
+			}
+			return fmt.Errorf("audit journal %s line %d is corrupt: %w", filepath.Base(name), n, err)
+		}
+		if err := fn(e, line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit journal: %w", err)
+	}
+	return nil
+}
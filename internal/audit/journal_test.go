@@ -0,0 +1,212 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// record opens a journal in a temporary directory and appends n entries
+func record(t *testing.T, n int) (*Journal, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	for i := 0; i < n; i++ {
+		j.Record(Entry{SessionID: "s" + string(rune('a'+i%3)), Type: "execute_command", Command: "df", Decision: Allowed})
+	}
+	return j, path
+}
+
+// editLines rewrites the journal file with edit applied to its lines
+func editLines(t *testing.T, path string, edit func(lines [][]byte) [][]byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if err := os.WriteFile(path, bytes.Join(edit(lines[:len(lines)-1]), nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(lines [][]byte) [][]byte
+		wantErr bool
+	}{
+		{"intact", func(lines [][]byte) [][]byte { return lines }, false},
+		{"modified", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"command":"df"`), []byte(`"command":"rm"`), 1)
+			return lines
+		}, true},
+		{"removed", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, true},
+		{"swapped", func(lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, true},
+		{"duplicated", func(lines [][]byte) [][]byte {
+			return append(lines[:2], lines[1:]...)
+		}, true},
+		{"truncated", func(lines [][]byte) [][]byte {
+			return lines[:len(lines)-1]
+		}, true},
+		{"corrupt line", func(lines [][]byte) [][]byte {
+			lines[2] = []byte("{not json\n")
+			return lines
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, path := record(t, 5)
+			editLines(t, path, tt.edit)
+			n, err := j.Verify()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Verify = %d entries, want error", n)
+				}
+				return
+			}
+			if err != nil || n != 5 {
+				t.Fatalf("Verify = %d, %v, want 5 entries", n, err)
+			}
+		})
+	}
+}
+
+func TestReopenContinuesChain(t *testing.T) {
+	j, path := record(t, 3)
+	head := j.Head()
+	j.Close()
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Head() != head {
+		t.Fatalf("head after reopen = %+v, want %+v", j.Head(), head)
+	}
+	j.Record(Entry{Type: "shutdown", Decision: Allowed})
+	if n, err := j.Verify(); err != nil || n != 4 {
+		t.Errorf("Verify = %d, %v, want 4 entries", n, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	j, path := record(t, 0)
+	j.MaxSize = 600 // A few entries per file
+	for i := 0; i < 20; i++ {
+		j.Record(Entry{Type: "execute_command", Command: "uptime", Decision: Allowed})
+	}
+
+	rotated, err := j.rotated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) < 2 {
+		t.Fatalf("%d rotated files, want several", len(rotated))
+	}
+	if n, err := j.Verify(); err != nil || n != 20 {
+		t.Fatalf("Verify = %d, %v, want 20 entries", n, err)
+	}
+
+	// The chain continues after reopening
+	j.Close()
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Head().Seq != 20 {
+		t.Fatalf("head after reopen = %d, want 20", j.Head().Seq)
+	}
+
+	// A rotated file in the middle is missing
+	if err := os.Remove(rotated[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Verify(); err == nil {
+		t.Error("Verify accepted a missing rotated file")
+	}
+}
+
+func TestRotationPrunes(t *testing.T) {
+	j, _ := record(t, 0)
+	j.MaxSize = 600
+	j.MaxFiles = 2
+	for i := 0; i < 30; i++ {
+		j.Record(Entry{Type: "execute_command", Command: "uptime", Decision: Allowed})
+	}
+
+	rotated, err := j.rotated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("%d rotated files, want 2", len(rotated))
+	}
+	// The chain starts at the oldest entry left
+	n, err := j.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n >= 30 {
+		t.Errorf("Verify = %d entries, want those of the remaining files", n)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	j, _ := record(t, 9)
+	j.Record(Entry{SessionID: "sa", Type: "read_log", Decision: Rejected})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{"all", Filter{}, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"session", Filter{SessionID: "sb"}, []uint64{2, 5, 8}},
+		{"type", Filter{Type: "read_log"}, []uint64{10}},
+		{"session and type", Filter{SessionID: "sa", Type: "execute_command"}, []uint64{1, 4, 7}},
+		{"limit keeps the last", Filter{Limit: 3}, []uint64{8, 9, 10}},
+		{"limit with session", Filter{SessionID: "sa", Limit: 2}, []uint64{7, 10}},
+		{"before", Filter{Before: 8, Limit: 3}, []uint64{5, 6, 7}},
+		{"before with session", Filter{SessionID: "sa", Before: 7}, []uint64{1, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := j.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for _, e := range entries {
+				got = append(got, e.Seq)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	j.Record(Entry{Type: "x"})
+	if n, err := j.Verify(); n != 0 || err != nil {
+		t.Errorf("Verify = %d, %v", n, err)
+	}
+	if entries, err := j.Query(Filter{}); entries != nil || err != nil {
+		t.Errorf("Query = %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// credentialFile is the file name inside the state directory
+const credentialFile = "credential.json"
+
+// ErrNotEnrolled is returned by LoadCredential when no credential exists yet
+var ErrNotEnrolled = errors.New("mate is not enrolled")
+
+// Credential is the long-lived secret issued by the Navigator on enrollment
+type Credential struct {
+	MateID   string    `json:"mate_id"`
+	Secret   string    `json:"secret"` // base64
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// LoadCredential reads the stored credential for mateID
+func LoadCredential(stateDir, mateID string) (*Credential, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, credentialFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential: %w", err)
+	}
+
+	var cred Credential
+	if err := json.Unmarshal(data, &cred); err != nil {
+		return nil, fmt.Errorf("failed to parse credential: %w", err)
+	}
+
+	// A credential belongs to exactly one mate ID
+	if cred.MateID != mateID {
+		return nil, ErrNotEnrolled
+	}
+	if _, err := cred.key(); err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+// Save writes the credential readable only by the agent user
+func (c *Credential) Save(stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(stateDir, credentialFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credential: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Sign answers an authentication challenge: hex(HMAC-SHA256(secret, mateID ":" nonce))
+func (c *Credential) Sign(nonce string) (string, error) {
+	key, err := c.key()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(c.MateID + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// key decodes the shared secret
+func (c *Credential) key() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(c.Secret)
+	if err != nil || len(key) < 16 {
+		return nil, fmt.Errorf("credential secret is invalid")
+	}
+	return key, nil
+}
@@ -2,22 +2,39 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
-	"strings"
+	"path/filepath"
+	"syscall"
 	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
+	"github.com/javafleet/fleet-mate-linux/internal/policy"
 )
 
-// CommandExecutor handles remote command execution with security whitelisting
+// CommandExecutor handles remote command execution checked against a command policy
 type CommandExecutor struct {
-	MateID string
+	MateID    string
+	MaxOutput int64 // Output bytes per session, 0 = unlimited
+	policy    *policy.Policy
+	journal   *audit.Journal
+	logger    *slog.Logger
 }
 
-// NewCommandExecutor creates a new command executor
-func NewCommandExecutor(mateID string) *CommandExecutor {
+// NewCommandExecutor creates a new command executor. A nil policy uses the
+// built-in policy, a nil journal disables auditing.
+func NewCommandExecutor(mateID string, p *policy.Policy, journal *audit.Journal) *CommandExecutor {
+	if p == nil {
+		p = policy.Default()
+	}
 	return &CommandExecutor{
-		MateID: mateID,
+		MateID:  mateID,
+		policy:  p,
+		journal: journal,
+		logger:  logging.For("commands"),
 	}
 }
 
@@ -28,62 +45,79 @@ type ExecuteCommandRequest struct {
 	Args       []string `json:"args"`
 	WorkingDir string   `json:"workingDir"`
 	Timeout    int      `json:"timeout"` // seconds
+	Explain    bool     `json:"explain"` // Dry run: only report the policy decision
+
+	FlushMode     string `json:"flushMode"`     // "line" (default) or "time"
+	FlushInterval int    `json:"flushInterval"` // milliseconds, default 200
 }
 
 // CommandOutputMessage represents output chunk message
 type CommandOutputMessage struct {
 	SessionID string `json:"sessionId"`
 	Content   string `json:"content"`
+	Stream    string `json:"stream,omitempty"` // "stdout" or "stderr", empty for mate notices
+	Seq       int64  `json:"seq,omitempty"`    // Chunk order within the session
 }
 
 // CommandCompleteMessage represents completion message
 type CommandCompleteMessage struct {
 	SessionID string `json:"sessionId"`
 	ExitCode  int    `json:"exitCode"`
+	Status    string `json:"status"`              // StatusCompleted, StatusTimeout, StatusCancelled or StatusRejected
+	Truncated bool   `json:"truncated,omitempty"` // Output exceeded the session limit
 }
 
-// Whitelisted commands (base commands only, without arguments)
-var allowedCommands = []string{
-	// System info
-	"df", "free", "uptime", "uname", "hostname", "whoami", "date",
-
-	// File operations (read-only)
-	"ls", "cat", "head", "tail", "grep", "find", "du", "pwd",
-
-	// Process monitoring
-	"ps", "top", "htop", "pgrep", "pidof",
-
-	// System services
-	"systemctl", "journalctl", "service",
-
-	// Network
-	"ping", "curl", "wget", "netstat", "ss", "ip", "ifconfig",
-
-	// Package info (read-only)
-	"dpkg", "apt", "yum", "rpm",
-
-	// Other utilities
-	"which", "whereis", "file", "stat", "wc", "sort", "uniq",
-	"dmesg", "lsblk", "lsusb", "lspci", "env",
+// CommandExplainMessage reports the policy decision of a dry run
+type CommandExplainMessage struct {
+	SessionID string `json:"sessionId"`
+	policy.Decision
 }
 
-// Forbidden dangerous commands (explicit blacklist for extra safety)
-var forbiddenCommands = []string{
-	"rm", "dd", "mkfs", "fdisk", "parted",
-	"chmod", "chown", "chgrp",
-	"useradd", "userdel", "usermod", "passwd",
-	"iptables", "ufw", "firewall-cmd",
-	"shutdown", "reboot", "init", "halt", "poweroff",
-}
+// Final session states reported in command_complete and log_complete
+const (
+	StatusCompleted = "completed"
+	StatusTimeout   = "timeout"
+	StatusCancelled = "cancelled"
+	StatusRejected  = "rejected"
+)
 
-// HandleExecuteCommand processes command execution request
-func (ce *CommandExecutor) HandleExecuteCommand(request ExecuteCommandRequest, sendMessage func(msgType string, data interface{})) error {
-	log.Printf("Executing command: %s %v (session: %s)", request.Command, request.Args, request.SessionID)
+// HandleExecuteCommand processes command execution request. Cancelling ctx
+// kills the command's whole process group.
+func (ce *CommandExecutor) HandleExecuteCommand(ctx context.Context, request ExecuteCommandRequest, sendMessage func(msgType string, data interface{})) error {
+	ce.logger.Info("Executing command", "command", request.Command, "args", request.Args, "session", request.SessionID)
+	started := time.Now()
 
 	// Security check
-	if !ce.isCommandAllowed(request.Command) {
-		errMsg := fmt.Sprintf("Command not whitelisted: %s", request.Command)
-		log.Printf("Security: %s", errMsg)
+	decision := ce.policy.Check(request.Command, request.Args, request.WorkingDir)
+	entry := audit.Entry{
+		SessionID: request.SessionID,
+		Type:      "execute_command",
+		Command:   request.Command,
+		Args:      request.Args,
+		Path:      decision.Path,
+		Decision:  audit.Allowed,
+		Rule:      decision.Rule,
+		Reason:    decision.Reason,
+	}
+	if !decision.Allowed {
+		entry.Decision = audit.Rejected
+	}
+
+	if request.Explain {
+		ce.logger.Info("Explaining command", "command", request.Command, "allowed", decision.Allowed,
+			"rule", decision.Rule, "reason", decision.Reason, "session", request.SessionID)
+		sendMessage("command_explain", CommandExplainMessage{
+			SessionID: request.SessionID,
+			Decision:  decision,
+		})
+		entry.Status = "explain"
+		ce.journal.Record(entry)
+		return nil
+	}
+	if !decision.Allowed {
+		errMsg := fmt.Sprintf("Command rejected by policy: %s", decision.Reason)
+		ce.logger.Warn("Security: command rejected", "command", request.Command, "args", request.Args,
+			"rule", decision.Rule, "reason", decision.Reason, "session", request.SessionID)
 		sendMessage("command_error", CommandOutputMessage{
 			SessionID: request.SessionID,
 			Content:   errMsg + "\n",
@@ -91,8 +125,11 @@ func (ce *CommandExecutor) HandleExecuteCommand(request ExecuteCommandRequest, s
 		sendMessage("command_complete", CommandCompleteMessage{
 			SessionID: request.SessionID,
 			ExitCode:  127, // Command not found
+			Status:    StatusRejected,
 		})
-		return fmt.Errorf(errMsg)
+		entry.Status = StatusRejected
+		ce.journal.Record(entry)
+		return errors.New(errMsg)
 	}
 
 	// Create context with timeout
@@ -100,39 +137,72 @@ func (ce *CommandExecutor) HandleExecuteCommand(request ExecuteCommandRequest, s
 	if timeout == 0 {
 		timeout = 300 * time.Second // Default: 5 minutes
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Build command with args
-	cmd := exec.CommandContext(ctx, request.Command, request.Args...)
+	// Run the resolved binary, argv[0] stays the requested name
+	cmd := exec.CommandContext(ctx, decision.Path, request.Args...)
+	cmd.Args[0] = filepath.Base(request.Command)
+
+	// Run in its own process group so timeout and cancel_session also stop
+	// children (e.g. a pipeline started by the command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	// Isolation configured in the policy (user, rlimits, namespaces, env)
+	if decision.Sandbox != nil {
+		decision.Sandbox.Apply(cmd)
+	}
 
 	// Set working directory if specified
 	if request.WorkingDir != "" {
 		cmd.Dir = request.WorkingDir
 	}
 
-	// Execute command and capture output
-	output, err := cmd.CombinedOutput()
+	// Stream stdout and stderr while the command runs
+	flushInterval := time.Duration(request.FlushInterval) * time.Millisecond
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	streamer := newOutputStreamer(request.SessionID, request.FlushMode, ce.MaxOutput, sendMessage)
+	cmd.Stdout = streamer.stdout
+	cmd.Stderr = streamer.stderr
+	// Don't hang on background children that keep the pipes open
+	cmd.WaitDelay = 2 * time.Second
 
-	if err != nil {
-		// Check if it was a timeout
-		if ctx.Err() == context.DeadlineExceeded {
-			sendMessage("command_error", CommandOutputMessage{
-				SessionID: request.SessionID,
-				Content:   fmt.Sprintf("Command timeout after %d seconds\n", request.Timeout),
-			})
-		} else {
+	stopFlush := make(chan struct{})
+	go streamer.run(flushInterval, stopFlush)
+
+	err := cmd.Run()
+
+	close(stopFlush)
+	streamer.close()
+
+	// Check if it was a timeout or cancelled by the Navigator
+	status := StatusCompleted
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		status = StatusTimeout
+		sendMessage("command_error", CommandOutputMessage{
+			SessionID: request.SessionID,
+			Content:   fmt.Sprintf("Command timeout after %d seconds\n", int(timeout.Seconds())),
+		})
+	} else if err != nil && ctx.Err() == context.Canceled {
+		status = StatusCancelled
+		sendMessage("command_error", CommandOutputMessage{
+			SessionID: request.SessionID,
+			Content:   "Command cancelled\n",
+		})
+	} else if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			entry.Reason = err.Error()
 			sendMessage("command_error", CommandOutputMessage{
 				SessionID: request.SessionID,
-				Content:   string(output),
+				Content:   err.Error() + "\n",
 			})
 		}
-	} else {
-		// Send stdout output
-		sendMessage("command_output", CommandOutputMessage{
-			SessionID: request.SessionID,
-			Content:   string(output),
-		})
 	}
 
 	// Get exit code
@@ -146,38 +216,23 @@ func (ce *CommandExecutor) HandleExecuteCommand(request ExecuteCommandRequest, s
 	}
 
 	// Send completion message
+	sent, truncated := streamer.sent()
 	sendMessage("command_complete", CommandCompleteMessage{
 		SessionID: request.SessionID,
 		ExitCode:  exitCode,
+		Status:    status,
+		Truncated: truncated,
 	})
 
-	log.Printf("Command completed: session=%s, exitCode=%d", request.SessionID, exitCode)
-	return nil
-}
-
-// isCommandAllowed checks if command is whitelisted
-func (ce *CommandExecutor) isCommandAllowed(command string) bool {
-	// Check if explicitly forbidden
-	for _, forbidden := range forbiddenCommands {
-		if command == forbidden {
-			return false
-		}
-	}
-
-	// Check if in whitelist
-	for _, allowed := range allowedCommands {
-		if command == allowed {
-			return true
-		}
-	}
-
-	// Also allow common paths
-	// e.g., /usr/bin/df, /bin/ls
-	if strings.HasPrefix(command, "/usr/bin/") || strings.HasPrefix(command, "/bin/") {
-		baseName := strings.TrimPrefix(command, "/usr/bin/")
-		baseName = strings.TrimPrefix(baseName, "/bin/")
-		return ce.isCommandAllowed(baseName)
+	entry.Status = status
+	entry.ExitCode = &exitCode
+	entry.Bytes = sent
+	if truncated {
+		entry.Reason = fmt.Sprintf("output truncated after %d bytes", ce.MaxOutput)
 	}
+	entry.DurationMs = time.Since(started).Milliseconds()
+	ce.journal.Record(entry)
 
-	return false
+	ce.logger.Info("Command completed", "session", request.SessionID, "exit_code", exitCode, "status", status)
+	return nil
 }
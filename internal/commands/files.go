@@ -0,0 +1,655 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
+	"github.com/javafleet/fleet-mate-linux/internal/pathacl"
+)
+
+// Chunk encodings for file transfers
+const (
+	EncodingBase64 = "base64" // file_chunk messages with base64 data
+	EncodingBinary = "binary" // Binary WebSocket frames, see EncodeFrame
+)
+
+const (
+	defaultFileChunkSize = 256 * 1024
+	minFileChunkSize     = 4 * 1024
+	maxFileChunkSize     = 1024 * 1024
+
+	// uploadIdleTimeout aborts an upload without chunks, the partial file
+	// is kept for a resume
+	uploadIdleTimeout = 5 * time.Minute
+)
+
+// FileTransfer handles file_download and file_upload within the allowed paths
+type FileTransfer struct {
+	MateID  string
+	MaxSize int64 // Bytes per file, 0 = unlimited
+	access  *pathacl.List
+	journal *audit.Journal
+	logger  *slog.Logger
+}
+
+// NewFileTransfer creates a file transfer handler. A nil journal disables
+// auditing.
+func NewFileTransfer(mateID string, access *pathacl.List, journal *audit.Journal) *FileTransfer {
+	return &FileTransfer{
+		MateID:  mateID,
+		access:  access,
+		journal: journal,
+		logger:  logging.For("commands"),
+	}
+}
+
+// FileDownloadRequest represents the file_download payload
+type FileDownloadRequest struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Offset    int64  `json:"offset"`    // Resume: first byte to send
+	ChunkSize int    `json:"chunkSize"` // Bytes per chunk, default 256 KB
+	Encoding  string `json:"encoding"`  // EncodingBase64 (default) or EncodingBinary
+}
+
+// FileUploadRequest represents the file_upload payload
+type FileUploadRequest struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`   // Total file size
+	SHA256    string `json:"sha256"` // Checksum of the whole file, hex
+	Offset    int64  `json:"offset"` // Resume: bytes the Navigator believes are already there
+	Mode      string `json:"mode"`   // Octal permissions, default: those of the replaced file or 0644
+}
+
+// FileInfoMessage starts a download
+type FileInfoMessage struct {
+	SessionID string    `json:"sessionId"`
+	Path      string    `json:"path"` // Resolved path
+	Size      int64     `json:"size"`
+	Mode      string    `json:"mode"`
+	ModTime   time.Time `json:"modTime"`
+	Offset    int64     `json:"offset"`
+	ChunkSize int       `json:"chunkSize"`
+	Encoding  string    `json:"encoding"`
+}
+
+// FileUploadReadyMessage tells the Navigator where to continue an upload
+type FileUploadReadyMessage struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Offset    int64  `json:"offset"` // Send chunks starting here
+}
+
+// FileChunkMessage is one chunk in a file_chunk message (both directions)
+type FileChunkMessage struct {
+	SessionID string `json:"sessionId"`
+	Seq       int64  `json:"seq"`
+	Offset    int64  `json:"offset"`
+	Data      string `json:"data"` // base64
+}
+
+// FileCompleteMessage ends a transfer
+type FileCompleteMessage struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`   // Bytes of the file transferred so far, including a resumed prefix
+	SHA256    string `json:"sha256"` // Checksum of these bytes
+	Status    string `json:"status"` // StatusCompleted, StatusCancelled or StatusIdle
+}
+
+// FileErrorMessage reports a refused or failed transfer
+type FileErrorMessage struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path,omitempty"`
+	Error     string `json:"error"`
+	Offset    *int64 `json:"offset,omitempty"` // Expected offset after a misplaced chunk, the upload continues
+}
+
+// FrameHeader precedes the payload of a binary frame
+type FrameHeader struct {
+	Type      string `json:"type"` // "file_chunk"
+	SessionID string `json:"sessionId"`
+	Seq       int64  `json:"seq"`
+	Offset    int64  `json:"offset"`
+}
+
+// EncodeFrame builds a binary WebSocket frame: the length of the JSON
+// header as 4 byte big-endian integer, the header and the raw payload
+func EncodeFrame(header FrameHeader, payload []byte) ([]byte, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, 4, 4+len(h)+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(h)))
+	frame = append(frame, h...)
+	return append(frame, payload...), nil
+}
+
+// DecodeFrame splits a binary frame into header and payload
+func DecodeFrame(frame []byte) (FrameHeader, []byte, error) {
+	var header FrameHeader
+	if len(frame) < 4 {
+		return header, nil, errors.New("binary frame too short")
+	}
+	n := binary.BigEndian.Uint32(frame)
+	if uint64(n) > uint64(len(frame)-4) {
+		return header, nil, errors.New("binary frame header exceeds frame")
+	}
+	if err := json.Unmarshal(frame[4:4+n], &header); err != nil {
+		return header, nil, fmt.Errorf("invalid binary frame header: %w", err)
+	}
+	return header, frame[4+n:], nil
+}
+
+// HandleDownload streams a file in sequenced chunks, starting at the
+// requested offset. The checksum in file_complete covers the whole file,
+// so a resumed download can be verified as well.
+func (ft *FileTransfer) HandleDownload(ctx context.Context, request FileDownloadRequest, sendMessage func(msgType string, data interface{}), sendBinary func(frame []byte) error) error {
+	ft.logger.Info("Downloading file", "path", request.Path, "offset", request.Offset, "session", request.SessionID)
+	started := time.Now()
+	entry := audit.Entry{
+		SessionID: request.SessionID,
+		Type:      "file_download",
+		Path:      request.Path,
+		Decision:  audit.Allowed,
+	}
+
+	fail := func(decision string, err error) error {
+		ft.logger.Warn("File download failed", "path", request.Path, "error", err, "session", request.SessionID)
+		sendMessage("file_error", FileErrorMessage{
+			SessionID: request.SessionID,
+			Path:      request.Path,
+			Error:     err.Error(),
+		})
+		entry.Decision = decision
+		entry.Status = StatusFailed
+		if decision == audit.Rejected {
+			entry.Status = StatusRejected
+		}
+		entry.Reason = err.Error()
+		entry.DurationMs = time.Since(started).Milliseconds()
+		ft.journal.Record(entry)
+		return err
+	}
+
+	path, err := ft.access.Resolve(request.Path)
+	if err != nil {
+		return fail(audit.Rejected, err)
+	}
+	entry.Path = path
+
+	encoding := request.Encoding
+	if encoding == "" {
+		encoding = EncodingBase64
+	}
+	if encoding != EncodingBase64 && encoding != EncodingBinary {
+		return fail(audit.Rejected, fmt.Errorf("unknown encoding %q", encoding))
+	}
+
+	// Check before opening, opening a FIFO would block
+	if info, err := os.Stat(path); err == nil && !info.Mode().IsRegular() {
+		return fail(audit.Rejected, fmt.Errorf("%s is not a regular file", path))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fail(audit.Allowed, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fail(audit.Allowed, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fail(audit.Rejected, fmt.Errorf("%s is not a regular file", path))
+	}
+	if ft.MaxSize > 0 && info.Size() > ft.MaxSize {
+		return fail(audit.Rejected, fmt.Errorf("%s is larger than %d bytes", path, ft.MaxSize))
+	}
+	if request.Offset < 0 || request.Offset > info.Size() {
+		return fail(audit.Rejected, fmt.Errorf("offset %d is outside the file (%d bytes)", request.Offset, info.Size()))
+	}
+
+	chunkSize := request.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultFileChunkSize
+	}
+	chunkSize = min(max(chunkSize, minFileChunkSize), maxFileChunkSize)
+
+	// The resumed prefix is only read for the checksum
+	sum := sha256.New()
+	if _, err := io.CopyN(sum, f, request.Offset); err != nil {
+		return fail(audit.Allowed, fmt.Errorf("failed to read %s: %w", path, err))
+	}
+
+	sendMessage("file_info", FileInfoMessage{
+		SessionID: request.SessionID,
+		Path:      path,
+		Size:      info.Size(),
+		Mode:      fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime:   info.ModTime(),
+		Offset:    request.Offset,
+		ChunkSize: chunkSize,
+		Encoding:  encoding,
+	})
+
+	offset := request.Offset
+	status := StatusCompleted
+	buf := make([]byte, chunkSize)
+	for seq := int64(1); ; seq++ {
+		if ctx.Err() != nil {
+			status = StatusCancelled
+			break
+		}
+
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum.Write(buf[:n])
+			if encoding == EncodingBinary {
+				frame, ferr := EncodeFrame(FrameHeader{
+					Type:      "file_chunk",
+					SessionID: request.SessionID,
+					Seq:       seq,
+					Offset:    offset,
+				}, buf[:n])
+				if ferr == nil {
+					ferr = sendBinary(frame)
+				}
+				if ferr != nil {
+					return fail(audit.Allowed, fmt.Errorf("failed to send chunk: %w", ferr))
+				}
+			} else {
+				sendMessage("file_chunk", FileChunkMessage{
+					SessionID: request.SessionID,
+					Seq:       seq,
+					Offset:    offset,
+					Data:      base64.StdEncoding.EncodeToString(buf[:n]),
+				})
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fail(audit.Allowed, fmt.Errorf("failed to read %s: %w", path, err))
+		}
+	}
+
+	checksum := hex.EncodeToString(sum.Sum(nil))
+	sendMessage("file_complete", FileCompleteMessage{
+		SessionID: request.SessionID,
+		Path:      path,
+		Size:      offset,
+		SHA256:    checksum,
+		Status:    status,
+	})
+
+	ft.logger.Info("File download finished", "path", path, "bytes", offset-request.Offset,
+		"status", status, "session", request.SessionID)
+	entry.Status = status
+	entry.Bytes = offset - request.Offset
+	entry.Reason = "sha256 " + checksum
+	entry.DurationMs = time.Since(started).Milliseconds()
+	ft.journal.Record(entry)
+	return nil
+}
+
+// Upload receives a file in chunks into a hidden partial file next to the
+// target and renames it over the target once size and checksum match.
+// An interrupted upload leaves the partial file for a resume.
+type Upload struct {
+	ft          *FileTransfer
+	request     FileUploadRequest
+	target      string
+	partial     string
+	mode        os.FileMode
+	owner       *syscall.Stat_t // Of the replaced file, kept on the new one when running as root
+	sendMessage func(msgType string, data interface{})
+	started     time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	sum     hash.Hash
+	resumed int64
+	written int64
+	idle    *time.Timer
+	done    chan struct{}
+}
+
+// OpenUpload checks the request and prepares the partial file. It replies
+// with file_upload_ready and the offset the Navigator must continue from.
+// The upload ends when all bytes arrived, ctx is cancelled or no chunk
+// arrives within the idle timeout.
+func (ft *FileTransfer) OpenUpload(ctx context.Context, request FileUploadRequest, sendMessage func(msgType string, data interface{})) (*Upload, error) {
+	ft.logger.Info("Receiving file", "path", request.Path, "size", request.Size, "offset", request.Offset, "session", request.SessionID)
+	u := &Upload{
+		ft:          ft,
+		request:     request,
+		sendMessage: sendMessage,
+		started:     time.Now(),
+		done:        make(chan struct{}),
+	}
+
+	if err := u.prepare(); err != nil {
+		u.fail(err)
+		return nil, err
+	}
+
+	sendMessage("file_upload_ready", FileUploadReadyMessage{
+		SessionID: request.SessionID,
+		Path:      u.target,
+		Offset:    u.written,
+	})
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.written == request.Size {
+		// Nothing left to send (empty file or complete partial file)
+		u.finish()
+		return u, nil
+	}
+	u.idle = time.AfterFunc(uploadIdleTimeout, func() { u.abort(StatusIdle) })
+	go func() {
+		select {
+		case <-ctx.Done():
+			u.abort(StatusCancelled)
+		case <-u.done:
+		}
+	}()
+	return u, nil
+}
+
+// prepare validates the request and opens the partial file
+func (u *Upload) prepare() error {
+	request := u.request
+	target, err := u.ft.access.Resolve(request.Path)
+	if err != nil {
+		return err
+	}
+	u.target = target
+
+	if request.Size < 0 {
+		return errors.New("size must not be negative")
+	}
+	if u.ft.MaxSize > 0 && request.Size > u.ft.MaxSize {
+		return fmt.Errorf("file is larger than %d bytes", u.ft.MaxSize)
+	}
+	if sum, err := hex.DecodeString(request.SHA256); err != nil || len(sum) != sha256.Size {
+		return errors.New("sha256 must be a hex encoded SHA-256 checksum")
+	}
+
+	u.mode = 0644
+	if info, err := os.Stat(target); err == nil {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", target)
+		}
+		u.mode = info.Mode().Perm()
+		u.owner, _ = info.Sys().(*syscall.Stat_t)
+	}
+	if request.Mode != "" {
+		m, err := strconv.ParseUint(request.Mode, 8, 32)
+		if err != nil || m > 0777 {
+			return fmt.Errorf("invalid mode %q", request.Mode)
+		}
+		u.mode = os.FileMode(m)
+	}
+
+	// A fixed name lets a new session resume what an interrupted one left.
+	// Others may write to the directory too, so the name must not lead to
+	// a file someone planted there.
+	u.partial = filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".fleet-upload")
+	f, err := os.OpenFile(u.partial, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create partial file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := checkPartial(info); err != nil {
+		f.Close()
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return fmt.Errorf("another upload to %s is in progress", target)
+	}
+
+	offset := min(max(request.Offset, 0), info.Size(), request.Size)
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+
+	u.sum = sha256.New()
+	if _, err := io.CopyN(u.sum, f, offset); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to read partial file: %w", err)
+	}
+	u.file = f
+	u.resumed = offset
+	u.written = offset
+	return nil
+}
+
+// checkPartial accepts only a partial file the mate created itself: a
+// regular file with a single link, owned by the mate's user
+func checkPartial(info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.Mode().IsRegular() || !ok || st.Nlink != 1 || int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("partial file %s was not created by the mate", info.Name())
+	}
+	return nil
+}
+
+// WriteChunk appends a chunk. Chunks must arrive in order; a chunk at the
+// wrong offset is answered with file_error and the expected offset.
+func (u *Upload) WriteChunk(offset int64, data []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.file == nil {
+		return errors.New("upload is finished")
+	}
+	if offset != u.written {
+		expected := u.written
+		u.sendMessage("file_error", FileErrorMessage{
+			SessionID: u.request.SessionID,
+			Path:      u.target,
+			Error:     fmt.Sprintf("chunk at offset %d, expected %d", offset, expected),
+			Offset:    &expected,
+		})
+		return nil
+	}
+	if u.written+int64(len(data)) > u.request.Size {
+		u.fail(fmt.Errorf("upload exceeds the announced size of %d bytes", u.request.Size))
+		return nil
+	}
+
+	if _, err := u.file.Write(data); err != nil {
+		u.fail(fmt.Errorf("failed to write partial file: %w", err))
+		return nil
+	}
+	u.sum.Write(data)
+	u.written += int64(len(data))
+	u.idle.Reset(uploadIdleTimeout)
+
+	if u.written == u.request.Size {
+		u.finish()
+	}
+	return nil
+}
+
+// Done is closed when the upload is over
+func (u *Upload) Done() <-chan struct{} {
+	return u.done
+}
+
+// finish verifies the checksum and atomically replaces the target.
+// Called with mu held.
+func (u *Upload) finish() {
+	checksum := hex.EncodeToString(u.sum.Sum(nil))
+	if checksum != u.request.SHA256 {
+		u.fail(fmt.Errorf("checksum mismatch: got %s, expected %s", checksum, u.request.SHA256))
+		return
+	}
+
+	f := u.file
+	err := f.Chmod(u.mode)
+	if err == nil && u.owner != nil && os.Geteuid() == 0 {
+		err = f.Chown(int(u.owner.Uid), int(u.owner.Gid))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		// The name could have been replaced since it was opened
+		err = u.samePartial()
+	}
+	if err == nil {
+		err = os.Rename(u.partial, u.target)
+	}
+	if err != nil {
+		u.fail(fmt.Errorf("failed to replace %s: %w", u.target, err))
+		return
+	}
+	syncDir(filepath.Dir(u.target))
+	u.close()
+
+	u.sendMessage("file_complete", FileCompleteMessage{
+		SessionID: u.request.SessionID,
+		Path:      u.target,
+		Size:      u.written,
+		SHA256:    checksum,
+		Status:    StatusCompleted,
+	})
+	u.ft.logger.Info("File upload completed", "path", u.target, "bytes", u.written, "session", u.request.SessionID)
+	u.record(audit.Allowed, StatusCompleted, "sha256 "+checksum)
+}
+
+// samePartial checks that the partial name still refers to the open file
+func (u *Upload) samePartial() error {
+	opened, err := u.file.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Lstat(u.partial)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(opened, current) {
+		return fmt.Errorf("partial file %s was replaced", filepath.Base(u.partial))
+	}
+	return nil
+}
+
+// fail reports an error and removes the partial file, a resume makes no
+// sense after it. Called with mu held (or before the upload started).
+func (u *Upload) fail(err error) {
+	u.ft.logger.Warn("File upload failed", "path", u.request.Path, "error", err, "session", u.request.SessionID)
+	u.sendMessage("file_error", FileErrorMessage{
+		SessionID: u.request.SessionID,
+		Path:      u.request.Path,
+		Error:     err.Error(),
+	})
+
+	decision, status := audit.Allowed, StatusFailed
+	if u.file == nil {
+		// Refused before anything was written
+		decision, status = audit.Rejected, StatusRejected
+	} else {
+		os.Remove(u.partial)
+		u.close()
+	}
+	u.record(decision, status, err.Error())
+}
+
+// abort stops an unfinished upload and keeps the partial file
+func (u *Upload) abort(status string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.file == nil {
+		return
+	}
+	u.file.Sync()
+	u.close()
+
+	u.sendMessage("file_complete", FileCompleteMessage{
+		SessionID: u.request.SessionID,
+		Path:      u.target,
+		Size:      u.written,
+		SHA256:    hex.EncodeToString(u.sum.Sum(nil)),
+		Status:    status,
+	})
+	u.ft.logger.Info("File upload interrupted", "path", u.target, "bytes", u.written, "status", status, "session", u.request.SessionID)
+	u.record(audit.Allowed, status, fmt.Sprintf("partial file kept at %d bytes", u.written))
+}
+
+// close releases the partial file and ends the upload
+func (u *Upload) close() {
+	if u.idle != nil {
+		u.idle.Stop()
+	}
+	if u.file != nil {
+		u.file.Close()
+		u.file = nil
+	}
+	close(u.done)
+}
+
+// record writes the audit entry of the upload
+func (u *Upload) record(decision, status, reason string) {
+	path := u.target
+	if path == "" {
+		path = u.request.Path
+	}
+	u.ft.journal.Record(audit.Entry{
+		SessionID:  u.request.SessionID,
+		Type:       "file_upload",
+		Path:       path,
+		Decision:   decision,
+		Status:     status,
+		Reason:     reason,
+		Bytes:      u.written - u.resumed,
+		DurationMs: time.Since(u.started).Milliseconds(),
+	})
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// DecodeChunk decodes the data of a file_chunk message
+func DecodeChunk(data string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 chunk: %w", err)
+	}
+	return b, nil
+}
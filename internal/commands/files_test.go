@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/javafleet/fleet-mate-linux/internal/pathacl"
+)
+
+// uploadDir returns a resolved temporary directory and a transfer allowed
+// to write to it
+func uploadDir(t *testing.T) (string, *FileTransfer) {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := pathacl.New([]string{dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, NewFileTransfer("mate", access, nil)
+}
+
+func upload(t *testing.T, ft *FileTransfer, path string, data []byte) error {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	request := FileUploadRequest{SessionID: "u1", Path: path, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	u, err := ft.OpenUpload(context.Background(), request, func(string, interface{}) {})
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := u.WriteChunk(0, data); err != nil {
+			return err
+		}
+	}
+	<-u.Done()
+	return nil
+}
+
+func TestUpload(t *testing.T) {
+	dir, ft := uploadDir(t)
+	target := filepath.Join(dir, "app.conf")
+	if err := upload(t, ft, target, []byte("key=value\n")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil || string(data) != "key=value\n" {
+		t.Fatalf("target = %q, %v", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, ".app.conf.fleet-upload")); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+func TestUploadPlantedPartial(t *testing.T) {
+	tests := []struct {
+		name  string
+		plant func(t *testing.T, partial, victim string)
+	}{
+		{"symlink", func(t *testing.T, partial, victim string) {
+			if err := os.Symlink(victim, partial); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"hard link", func(t *testing.T, partial, victim string) {
+			if err := os.Link(victim, partial); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, ft := uploadDir(t)
+			victim := filepath.Join(t.TempDir(), "victim")
+			if err := os.WriteFile(victim, []byte("keep me"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			tt.plant(t, filepath.Join(dir, ".app.conf.fleet-upload"), victim)
+
+			if err := upload(t, ft, filepath.Join(dir, "app.conf"), []byte("x")); err == nil {
+				t.Fatal("upload used the planted partial file")
+			}
+			if data, _ := os.ReadFile(victim); string(data) != "keep me" {
+				t.Errorf("victim changed to %q", data)
+			}
+		})
+	}
+}
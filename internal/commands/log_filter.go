@@ -0,0 +1,351 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxContextLines caps the before and after context of read_log
+	maxContextLines = 100
+
+	// maxPatternLength caps include and exclude expressions
+	maxPatternLength = 1024
+)
+
+// Keywords that indicate important log entries (smart mode). They match at
+// the start of a word ("errors" but not "terror"); counts of zero like
+// "0 errors" or "no failures" are ignored.
+var smartKeywords = []string{
+	"error", "ERROR", "Error",
+	"warn", "WARN", "warning", "Warning",
+	"fail", "FAIL", "failed", "Failed",
+	"critical", "CRITICAL", "Critical",
+	"panic", "Panic", "PANIC",
+	"segfault", "segmentation fault",
+	"out of memory", "OOM", "oom",
+	"authentication failure", "auth failed",
+	"denied", "Denied", "DENIED",
+	"timeout", "Timeout", "TIMEOUT",
+	"refused", "Refused", "REFUSED",
+	"exception", "Exception", "EXCEPTION",
+}
+
+// Only critical keywords (errors-only mode)
+var errorKeywords = []string{
+	"error", "ERROR", "Error",
+	"critical", "CRITICAL", "Critical",
+	"panic", "Panic", "PANIC",
+	"fail", "FAIL", "failed", "Failed",
+	"segfault", "segmentation fault",
+	"exception", "Exception", "EXCEPTION",
+}
+
+// lineFilter selects the lines a read_log request sends
+type lineFilter struct {
+	keywords *regexp.Regexp // Mode keywords, nil matches all
+	include  *regexp.Regexp // Lines must match, nil matches all
+	exclude  *regexp.Regexp // Lines must not match
+	since    time.Time      // Time window, zero = open
+	until    time.Time
+	before   int    // Context lines sent before a match
+	after    int    // Context lines sent after a match
+	fallback int    // Last lines sent when nothing matches
+	notice   string // Sent instead when nothing matches
+}
+
+// newLineFilter builds the filter of a request. The journal source
+// filters by priority instead of keywords.
+func newLineFilter(request ReadLogRequest, now time.Time) (lineFilter, error) {
+	var f lineFilter
+	var err error
+
+	keywords := map[string][]string{"smart": smartKeywords, "errors-only": errorKeywords}[request.Mode]
+	switch {
+	case request.Source == SourceJournal:
+		if request.Mode == "errors-only" {
+			f.notice = "No errors found in journal."
+		}
+	case keywords != nil:
+		f.keywords = keywordPattern(keywords, request.IgnoreCase)
+		if request.Mode == "errors-only" {
+			f.notice = "No errors found in log file."
+		}
+	}
+
+	if f.include, err = compilePattern("include", request.Include, request.IgnoreCase); err != nil {
+		return f, err
+	}
+	if f.exclude, err = compilePattern("exclude", request.Exclude, request.IgnoreCase); err != nil {
+		return f, err
+	}
+	if f.since, err = parseTimeArg("since", request.Since, now); err != nil {
+		return f, err
+	}
+	if f.until, err = parseTimeArg("until", request.Until, now); err != nil {
+		return f, err
+	}
+	if !f.since.IsZero() && !f.until.IsZero() && f.until.Before(f.since) {
+		return f, fmt.Errorf("until %s is before since %s", request.Until, request.Since)
+	}
+
+	if request.Before < 0 || request.After < 0 {
+		return f, fmt.Errorf("context lines must not be negative")
+	}
+	f.before = min(request.Before, maxContextLines)
+	f.after = min(request.After, maxContextLines)
+
+	// If filtering results in empty output, smart mode returns at least
+	// some context, unless the Navigator asked for something specific
+	if request.Mode == "smart" && f.keywords != nil && f.include == nil && f.since.IsZero() && f.until.IsZero() {
+		f.fallback = 50
+	}
+	return f, nil
+}
+
+// zeroCountPattern finds phrases that mention a keyword without reporting
+// a problem
+var zeroCountPattern = regexp.MustCompile(`(?i)\b(?:0|no|zero|without) (?:errors?|warnings?|failures?|failed|exceptions?)\b`)
+
+// keywordPattern matches any of the keywords at the start of a word
+func keywordPattern(keywords []string, ignoreCase bool) *regexp.Regexp {
+	quoted := make([]string, len(keywords))
+	for i, k := range keywords {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	expr := `\b(?:` + strings.Join(quoted, "|") + `)`
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile(expr)
+}
+
+// compilePattern compiles an include or exclude expression
+func compilePattern(name, expr string, ignoreCase bool) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if len(expr) > maxPatternLength {
+		return nil, fmt.Errorf("%s pattern is longer than %d characters", name, maxPatternLength)
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %w", name, err)
+	}
+	return re, nil
+}
+
+// timed reports whether the filter has a time window
+func (f lineFilter) timed() bool {
+	return !f.since.IsZero() || !f.until.IsZero()
+}
+
+// matcher returns a matcher for the lines of one source
+func (f lineFilter) matcher() *lineMatcher {
+	return &lineMatcher{filter: f, now: time.Now()}
+}
+
+// lineMatcher applies a filter to the lines of one source. Lines without
+// a timestamp (continuations, stack traces) get the time of the line seen
+// before; when reading backwards, that is the newer one.
+type lineMatcher struct {
+	filter lineFilter
+	now    time.Time
+	last   time.Time // Zero until a timestamp was seen
+}
+
+// match reports whether the line is sent
+func (m *lineMatcher) match(line string) bool {
+	f := m.filter
+	if f.timed() {
+		if t, ok := lineTime(line, m.now); ok {
+			m.last = t
+		}
+		if m.last.IsZero() || (!f.since.IsZero() && m.last.Before(f.since)) || (!f.until.IsZero() && m.last.After(f.until)) {
+			return false
+		}
+	}
+	if f.keywords != nil {
+		text := line
+		if zeroCountPattern.MatchString(text) {
+			text = zeroCountPattern.ReplaceAllString(text, "")
+		}
+		if !f.keywords.MatchString(text) {
+			return false
+		}
+	}
+	if f.include != nil && !f.include.MatchString(line) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(line)
+}
+
+// contextLine is a line held back as possible before context
+type contextLine struct {
+	n    int // Position in the source
+	text string
+}
+
+// lineSelector adds grep-style context to the matches of one source and
+// separates groups that aren't adjacent with "--"
+type lineSelector struct {
+	matcher   *lineMatcher
+	emit      func(line string) bool
+	skip      int // Matches not sent, they only serve as context
+	n         int // Lines seen
+	printed   int // Position of the last sent line, 0 = none
+	afterLeft int
+	held      []contextLine
+}
+
+// newLineSelector creates a selector that passes lines to emit
+func newLineSelector(filter lineFilter, emit func(line string) bool) *lineSelector {
+	return &lineSelector{matcher: filter.matcher(), emit: emit}
+}
+
+// feed processes the next line. It reports whether it matched and whether
+// the transfer goes on.
+func (s *lineSelector) feed(line string) (matched, more bool) {
+	s.n++
+	f := s.matcher.filter
+	matched = s.matcher.match(line)
+	if matched && s.skip > 0 {
+		s.skip--
+		matched = false
+	}
+
+	switch {
+	case matched:
+		for _, h := range s.held {
+			if !s.send(h.n, h.text) {
+				return true, false
+			}
+		}
+		s.held = s.held[:0]
+		s.afterLeft = f.after
+		return true, s.send(s.n, line)
+	case s.afterLeft > 0:
+		s.afterLeft--
+		return false, s.send(s.n, line)
+	case f.before > 0:
+		if len(s.held) == f.before {
+			s.held = append(s.held[:0], s.held[1:]...)
+		}
+		s.held = append(s.held, contextLine{n: s.n, text: line})
+	}
+	return false, true
+}
+
+// send passes a line on, after a separator if lines were left out
+func (s *lineSelector) send(n int, line string) bool {
+	f := s.matcher.filter
+	if (f.before > 0 || f.after > 0) && s.printed > 0 && n > s.printed+1 {
+		if !s.emit("--") {
+			return false
+		}
+	}
+	s.printed = n
+	return s.emit(line)
+}
+
+var (
+	// Jan  2 15:04:05, classic syslog and journalctl's short format
+	syslogTimePattern = regexp.MustCompile(`^([A-Z][a-z]{2}) +(\d{1,2}) (\d{2}:\d{2}:\d{2})`)
+	// [02/Jan/2006:15:04:05 -0700], nginx and Apache access logs
+	nginxTimePattern = regexp.MustCompile(`\[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`)
+	// 2006-01-02T15:04:05.000+01:00, also with a space, "Z" or "+0100"
+	// (journalctl short-iso, RFC 5424 syslog, most application logs)
+	isoTimePattern = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2})(?:[.,]\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+)
+
+// lineTime finds the timestamp near the start of a log line. Timestamps
+// without a zone are local time; syslog's lack a year, the one that puts
+// them closest before now is taken.
+func lineTime(line string, now time.Time) (time.Time, bool) {
+	head := line
+	if len(head) > 128 {
+		head = head[:128]
+	}
+
+	if m := syslogTimePattern.FindStringSubmatch(head); m != nil {
+		t, err := time.ParseInLocation("Jan 2 15:04:05 2006", fmt.Sprintf("%s %s %s %d", m[1], m[2], m[3], now.Year()), time.Local)
+		if err == nil {
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, true
+		}
+	}
+	if m := nginxTimePattern.FindStringSubmatch(head); m != nil {
+		if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1]); err == nil {
+			return t, true
+		}
+	}
+	if m := isoTimePattern.FindStringSubmatch(head); m != nil {
+		return parseISOTime(m[1], m[2], m[3])
+	}
+	return time.Time{}, false
+}
+
+// parseISOTime parses the parts of an ISO-8601 timestamp, fractions of a
+// second are dropped
+func parseISOTime(date, clock, zone string) (time.Time, bool) {
+	value := date + "T" + clock
+	var (
+		t   time.Time
+		err error
+	)
+	switch {
+	case zone == "":
+		t, err = time.ParseInLocation("2006-01-02T15:04:05", value, time.Local)
+	case zone == "Z":
+		t, err = time.Parse("2006-01-02T15:04:05Z", value+zone)
+	case strings.Contains(zone, ":"):
+		t, err = time.Parse("2006-01-02T15:04:05-07:00", value+zone)
+	default:
+		t, err = time.Parse("2006-01-02T15:04:05-0700", value+zone)
+	}
+	return t, err == nil
+}
+
+// parseTimeArg parses since and until: RFC 3339, "2006-01-02 15:04[:05]",
+// a date, "@<unix seconds>", "now", "today", "yesterday" or a duration
+// relative to now ("-2h30m"). Values without a zone are local time.
+func parseTimeArg(name, value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	switch {
+	case value == "":
+		return time.Time{}, nil
+	case value == "now":
+		return now, nil
+	case value == "today":
+		return today, nil
+	case value == "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case strings.HasPrefix(value, "@"):
+		if sec, err := strconv.ParseInt(value[1:], 10, 64); err == nil {
+			return time.Unix(sec, 0), nil
+		}
+	case strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+"):
+		if d, err := time.ParseDuration(value); err == nil {
+			return now.Add(d), nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s time %q", name, value)
+}
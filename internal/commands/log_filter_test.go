@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineTime(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		line string
+		want time.Time // Zero = no timestamp
+	}{
+		{"syslog", "Mar  9 08:15:02 pi sshd[812]: Accepted key", time.Date(2025, 3, 9, 8, 15, 2, 0, time.Local)},
+		{"syslog two-digit day", "Mar 10 11:59:59 pi cron: tick", time.Date(2025, 3, 10, 11, 59, 59, 0, time.Local)},
+		{"syslog last year", "Dec 31 23:00:00 pi kernel: usb", time.Date(2024, 12, 31, 23, 0, 0, 0, time.Local)},
+		{"syslog tomorrow stays", "Mar 11 06:00:00 pi app: skew", time.Date(2025, 3, 11, 6, 0, 0, 0, time.Local)},
+		{"nginx", `10.0.0.1 - - [10/Mar/2025:10:00:00 +0100] "GET / HTTP/1.1" 200`, time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"iso utc", "2025-03-10T10:00:00Z INFO started", time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC)},
+		{"iso fraction and offset", "2025-03-10T10:00:00.123+01:00 started", time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"iso compact offset", "2025-03-10T10:00:00+0100 pi app: x", time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)},
+		{"iso space local", "2025-03-10 10:00:00,456 WARN x", time.Date(2025, 3, 10, 10, 0, 0, 0, time.Local)},
+		{"iso in prefix", "[app] 2025-03-10T10:00:00Z x", time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC)},
+		{"continuation", "    at com.example.Main.run(Main.java:42)", time.Time{}},
+		{"beyond head", strings.Repeat("x", 130) + " 2025-03-10T10:00:00Z", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := lineTime(tt.line, now)
+			if ok != !tt.want.IsZero() {
+				t.Fatalf("lineTime found = %v, want %v", ok, !tt.want.IsZero())
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("lineTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 30, 0, 0, time.Local)
+	today := time.Date(2025, 3, 10, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "", want: time.Time{}},
+		{value: "now", want: now},
+		{value: "today", want: today},
+		{value: "yesterday", want: today.AddDate(0, 0, -1)},
+		{value: " today ", want: today},
+		{value: "@1700000000", want: time.Unix(1700000000, 0)},
+		{value: "-2h30m", want: now.Add(-150 * time.Minute)},
+		{value: "+15m", want: now.Add(15 * time.Minute)},
+		{value: "2025-03-09T08:00:00Z", want: time.Date(2025, 3, 9, 8, 0, 0, 0, time.UTC)},
+		{value: "2025-03-09T08:00:00+01:00", want: time.Date(2025, 3, 9, 7, 0, 0, 0, time.UTC)},
+		{value: "2025-03-09 08:00:05", want: time.Date(2025, 3, 9, 8, 0, 5, 0, time.Local)},
+		{value: "2025-03-09 08:00", want: time.Date(2025, 3, 9, 8, 0, 0, 0, time.Local)},
+		{value: "2025-03-09", want: time.Date(2025, 3, 9, 0, 0, 0, 0, time.Local)},
+		{value: "@abc", wantErr: true},
+		{value: "-2 hours", wantErr: true},
+		{value: "last week", wantErr: true},
+		{value: "2025-13-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseTimeArg("since", tt.value, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTimeArg = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTimeArg = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLineFilterRange(t *testing.T) {
+	now := time.Now()
+	if _, err := newLineFilter(ReadLogRequest{Since: "today", Until: "yesterday"}, now); err == nil {
+		t.Error("until before since accepted")
+	}
+	if _, err := newLineFilter(ReadLogRequest{Before: -1}, now); err == nil {
+		t.Error("negative context accepted")
+	}
+	if _, err := newLineFilter(ReadLogRequest{Include: "("}, now); err == nil {
+		t.Error("invalid include accepted")
+	}
+}
+
+func TestLineSelector(t *testing.T) {
+	lines := []string{"a", "b", "ERR 1", "c", "d", "e", "f", "ERR 2", "g", "ERR 3", "h", "i"}
+
+	tests := []struct {
+		name    string
+		request ReadLogRequest
+		skip    int
+		limit   int // Lines emit accepts, 0 = all
+		want    []string
+	}{
+		{
+			name:    "no context",
+			request: ReadLogRequest{Include: "ERR"},
+			want:    []string{"ERR 1", "ERR 2", "ERR 3"},
+		},
+		{
+			name:    "before and after",
+			request: ReadLogRequest{Include: "ERR", Before: 1, After: 1},
+			want:    []string{"b", "ERR 1", "c", "--", "f", "ERR 2", "g", "ERR 3", "h"},
+		},
+		{
+			name:    "adjacent groups merge",
+			request: ReadLogRequest{Include: "ERR", Before: 2},
+			want:    []string{"a", "b", "ERR 1", "--", "e", "f", "ERR 2", "g", "ERR 3"},
+		},
+		{
+			name:    "after only",
+			request: ReadLogRequest{Include: "ERR", After: 2},
+			want:    []string{"ERR 1", "c", "d", "--", "ERR 2", "g", "ERR 3", "h", "i"},
+		},
+		{
+			name:    "exclude",
+			request: ReadLogRequest{Include: "ERR", Exclude: "2"},
+			want:    []string{"ERR 1", "ERR 3"},
+		},
+		{
+			name:    "skipped matches serve as context",
+			request: ReadLogRequest{Include: "ERR", Before: 1},
+			skip:    2,
+			want:    []string{"g", "ERR 3"},
+		},
+		{
+			name:    "ignore case",
+			request: ReadLogRequest{Include: "err", IgnoreCase: true},
+			want:    []string{"ERR 1", "ERR 2", "ERR 3"},
+		},
+		{
+			name:    "emit stops",
+			request: ReadLogRequest{Include: "ERR", Before: 1, After: 1},
+			limit:   4,
+			want:    []string{"b", "ERR 1", "c", "--"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newLineFilter(tt.request, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			s := newLineSelector(filter, func(line string) bool {
+				got = append(got, line)
+				return tt.limit == 0 || len(got) < tt.limit
+			})
+			s.skip = tt.skip
+			for _, line := range lines {
+				if _, more := s.feed(line); !more {
+					break
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSmartModeKeywords(t *testing.T) {
+	filter, err := newLineFilter(ReadLogRequest{Mode: "smart"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"connection refused by peer", true},
+		{"Job failed with exit code 1", true},
+		{"ERROR: disk full", true},
+		{"a terror movie", false},
+		{"finished with 0 errors", false},
+		{"build completed, no warnings", false},
+		{"ok", false},
+	}
+	m := filter.matcher()
+	for _, tt := range tests {
+		if got := m.match(tt.line); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// followInterval is how often a followed file is checked for new lines
+	followInterval = 500 * time.Millisecond
+
+	// defaultFollowIdle ends a follow session without new lines
+	defaultFollowIdle = 10 * time.Minute
+
+	// maxFollowIdle caps the idle timeout a request may ask for
+	maxFollowIdle = time.Hour
+)
+
+// followIdle returns the idle timeout for the requested seconds
+func followIdle(seconds int) time.Duration {
+	switch {
+	case seconds <= 0:
+		return defaultFollowIdle
+	case seconds > int(maxFollowIdle/time.Second):
+		return maxFollowIdle
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// logFollower sends lines appended to a log file, like tail -F. Rotation
+// is detected by the inode behind the path (rename and recreate) and by
+// the file shrinking below the read offset (copytruncate).
+type logFollower struct {
+	lr        *LogReader
+	requested string // Path as requested, resolved again after rotation
+	file      *os.File
+	offset    int64
+	pending   []byte // Incomplete last line
+	selector  *lineSelector
+	stream    *logStream
+}
+
+// run follows the file until ctx is done, the output limit is reached or
+// no new lines arrive within idle. It returns the final status.
+func (f *logFollower) run(idle time.Duration) (string, error) {
+	f.stream.flush(false)
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	lastData := time.Now()
+
+	for {
+		select {
+		case <-f.stream.ctx.Done():
+			return StatusCancelled, nil
+		case <-ticker.C:
+		}
+
+		n, err := f.poll()
+		if err != nil {
+			return StatusFailed, err
+		}
+		if f.stream.truncated || f.stream.ctx.Err() != nil {
+			return StatusCompleted, nil
+		}
+		if n > 0 {
+			lastData = time.Now()
+			f.stream.flush(false)
+		} else if time.Since(lastData) >= idle {
+			f.lr.logger.Info("Log follow idle", "session", f.stream.sessionID, "idle", idle)
+			return StatusIdle, nil
+		}
+	}
+}
+
+// poll reads what was appended since the last call and handles rotation.
+// It returns the number of bytes read.
+func (f *logFollower) poll() (int64, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < f.offset {
+		f.lr.logger.Info("Log file truncated, reading from start", "session", f.stream.sessionID, "path", f.file.Name())
+		f.offset = 0
+		f.pending = nil
+		f.stream.add("[log truncated, reading from start]")
+	}
+
+	n, err := f.drain()
+	if err != nil {
+		return n, err
+	}
+
+	// Rotated: the path now names another file. The old file was drained
+	// above, lines written to it after this point are lost.
+	current, err := os.Stat(f.requested)
+	if err != nil || os.SameFile(info, current) {
+		// Missing between rename and recreate, try again next time
+		return n, nil
+	}
+	if err := f.reopen(); err != nil {
+		return n, err
+	}
+	m, err := f.drain()
+	return n + m, err
+}
+
+// reopen switches to the file that now exists at the requested path. The
+// path is checked again, the new file could be a link elsewhere.
+func (f *logFollower) reopen() error {
+	path, err := f.lr.access.Resolve(f.requested)
+	if err != nil {
+		return err
+	}
+	file, _, err := openRegular(path)
+	if err != nil {
+		return err
+	}
+
+	f.lr.logger.Info("Log file rotated, following new file", "session", f.stream.sessionID, "path", path)
+	if len(f.pending) > 0 {
+		f.stream.add(string(f.pending))
+	}
+	f.file.Close()
+	f.file = file
+	f.offset = 0
+	f.pending = nil
+	f.stream.add("[log rotated]")
+	return nil
+}
+
+// drain reads the file up to its current end and sends complete lines
+func (f *logFollower) drain() (int64, error) {
+	buf := make([]byte, tailBlockSize)
+	var total int64
+	for {
+		n, err := f.file.ReadAt(buf, f.offset)
+		f.offset += int64(n)
+		total += int64(n)
+
+		data := append(f.pending, buf[:n]...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if !f.send(data[:i]) {
+				return total, nil
+			}
+			data = data[i+1:]
+		}
+		// An overlong line is sent in pieces
+		for len(data) >= maxLineLength {
+			if !f.send(data[:maxLineLength]) {
+				return total, nil
+			}
+			data = data[maxLineLength:]
+		}
+		f.pending = append(f.pending[:0:0], data...)
+
+		if err == io.EOF || n == 0 {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// send passes a line through the filter, false stops reading
+func (f *logFollower) send(line []byte) bool {
+	_, more := f.selector.feed(string(line))
+	return more
+}
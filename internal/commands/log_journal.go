@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log sources of read_log
+const (
+	SourceFile    = "file"
+	SourceJournal = "journal"
+)
+
+// journalModePriority is the lowest priority sent in a read_log mode, the
+// journal's counterpart of the keyword filters
+var journalModePriority = map[string]string{
+	"smart":       "warning",
+	"errors-only": "err",
+}
+
+var (
+	journalUnitPattern     = regexp.MustCompile(`^[A-Za-z0-9:_.@\\][A-Za-z0-9:_.@\\-]*$`)
+	journalPriorityPattern = regexp.MustCompile(`^(emerg|alert|crit|err|warning|notice|info|debug|[0-7])(\.\.(emerg|alert|crit|err|warning|notice|info|debug|[0-7]))?$`)
+	journalBootPattern     = regexp.MustCompile(`^([0-9a-f]{32}|[+-]?[0-9]+)$`)
+)
+
+// journalArgs validates the journal filters of a request and turns them
+// into journalctl arguments. Values are passed as --option=value, so they
+// can't be taken for further options.
+func journalArgs(request ReadLogRequest, filter lineFilter) ([]string, error) {
+	args := []string{"--output=json", "--no-pager"}
+
+	if request.Unit != "" {
+		if !journalUnitPattern.MatchString(request.Unit) {
+			return nil, fmt.Errorf("invalid unit %q", request.Unit)
+		}
+		args = append(args, "--unit="+request.Unit)
+	}
+
+	priority := request.Priority
+	if priority == "" {
+		priority = journalModePriority[request.Mode]
+	}
+	if priority != "" {
+		if !journalPriorityPattern.MatchString(priority) {
+			return nil, fmt.Errorf("invalid priority %q", priority)
+		}
+		args = append(args, "--priority="+priority)
+	}
+
+	if request.Boot != "" {
+		if !journalBootPattern.MatchString(request.Boot) {
+			return nil, fmt.Errorf("invalid boot %q", request.Boot)
+		}
+		args = append(args, "--boot="+request.Boot)
+	}
+
+	// The time range is already parsed, journalctl gets it as unix time
+	if !filter.since.IsZero() {
+		args = append(args, fmt.Sprintf("--since=@%d", filter.since.Unix()))
+	}
+	if !filter.until.IsZero() {
+		args = append(args, fmt.Sprintf("--until=@%d", filter.until.Unix()))
+	}
+
+	switch {
+	case request.Lines > 0:
+		args = append(args, "--lines="+strconv.Itoa(min(request.Lines, maxTailLines)))
+	case request.Follow:
+		// journalctl --follow starts with the last 10 entries otherwise
+		args = append(args, "--lines=all")
+	}
+	if request.Follow {
+		args = append(args, "--follow")
+	}
+	return args, nil
+}
+
+// readJournal streams journal entries from journalctl. In follow mode it
+// runs until ctx is done or no entry arrives within the idle timeout.
+func (lr *LogReader) readJournal(ctx context.Context, args []string, request ReadLogRequest, filter lineFilter, stream *logStream) (string, error) {
+	path, err := exec.LookPath("journalctl")
+	if err != nil {
+		return StatusFailed, errors.New("journalctl is not installed")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return StatusFailed, err
+	}
+	if err := cmd.Start(); err != nil {
+		return StatusFailed, err
+	}
+
+	entries, decodeErr := decodeJournal(ctx, out)
+
+	idle := followIdle(request.IdleTimeout)
+	lastEntry := time.Now()
+	// Entries arriving in follow mode are sent without waiting for a full chunk
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	// Priorities are filtered by journalctl, include, exclude and context here
+	selector := newLineSelector(filter, stream.add)
+	status := StatusCompleted
+	matched := 0
+read:
+	for {
+		select {
+		case <-stream.ctx.Done():
+			break read
+		case <-ticker.C:
+			if !request.Follow {
+				continue
+			}
+			if time.Since(lastEntry) >= idle {
+				lr.logger.Info("Journal follow idle", "session", stream.sessionID, "idle", idle)
+				status = StatusIdle
+				break read
+			}
+			stream.flush(false)
+		case entry, ok := <-entries:
+			if !ok {
+				break read
+			}
+			lastEntry = time.Now()
+			for _, line := range entry.lines() {
+				ok, more := selector.feed(line)
+				if ok {
+					matched++
+				}
+				if !more {
+					break read
+				}
+			}
+		}
+	}
+
+	// Stop journalctl when reading ended early, then collect its status
+	finished := stream.ctx.Err() == nil && status == StatusCompleted && !stream.truncated
+	if !finished {
+		cancel()
+	}
+	out.Close()
+	waitErr := cmd.Wait()
+	if finished {
+		select {
+		case err := <-decodeErr:
+			return StatusFailed, fmt.Errorf("invalid journal output: %w", err)
+		default:
+		}
+		if waitErr != nil {
+			return StatusFailed, fmt.Errorf("journalctl: %w: %s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+		if matched == 0 && filter.notice != "" {
+			stream.add(filter.notice)
+		}
+		stream.progress = 100
+	}
+	return status, nil
+}
+
+// decodeJournal decodes the JSON entries journalctl writes, one object per
+// line. A decoding error is reported once the channel is closed.
+func decodeJournal(ctx context.Context, r io.Reader) (<-chan journalEntry, <-chan error) {
+	entries := make(chan journalEntry, 64)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(entries)
+		dec := json.NewDecoder(r)
+		for {
+			var entry journalEntry
+			if err := dec.Decode(&entry); err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					errs <- err
+				}
+				return
+			}
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return entries, errs
+}
+
+// journalEntry is one entry of journalctl's JSON output
+type journalEntry map[string]interface{}
+
+// field returns a field as string. Binary values come as byte arrays,
+// fields set more than once as arrays of strings (the first is used), and
+// oversized fields as null.
+func (e journalEntry) field(name string) string {
+	switch v := e[name].(type) {
+	case string:
+		return v
+	case []interface{}:
+		data := make([]byte, 0, len(v))
+		for _, item := range v {
+			switch x := item.(type) {
+			case float64:
+				data = append(data, byte(x))
+			case string:
+				return x
+			}
+		}
+		return string(data)
+	}
+	return ""
+}
+
+// lines formats the entry like journalctl -o short-iso. Continuation lines
+// of a multi-line message are indented.
+func (e journalEntry) lines() []string {
+	timestamp := ""
+	if usec, err := strconv.ParseInt(e.field("__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		timestamp = time.UnixMicro(usec).Format("2006-01-02T15:04:05-0700")
+	}
+	ident := e.field("SYSLOG_IDENTIFIER")
+	if ident == "" {
+		ident = e.field("_COMM")
+	}
+	if pid := e.field("_PID"); pid != "" {
+		ident += "[" + pid + "]"
+	}
+
+	prefix := fmt.Sprintf("%s %s %s: ", timestamp, e.field("_HOSTNAME"), ident)
+	message := strings.Split(strings.TrimRight(e.field("MESSAGE"), "\n"), "\n")
+	lines := make([]string, len(message))
+	for i, text := range message {
+		if i == 0 {
+			lines[i] = prefix + text
+		} else {
+			lines[i] = strings.Repeat(" ", len(prefix)) + text
+		}
+		if len(lines[i]) > maxLineLength {
+			lines[i] = lines[i][:maxLineLength]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalEntryField(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  string
+	}{
+		{"string", `{"MESSAGE":"hello"}`, "hello"},
+		{"byte array", `{"MESSAGE":[104,105,10,0]}`, "hi\n\x00"},
+		{"multiple values", `{"MESSAGE":["first","second"]}`, "first"},
+		{"null for oversized", `{"MESSAGE":null}`, ""},
+		{"missing", `{}`, ""},
+		{"number", `{"MESSAGE":42}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e journalEntry
+			if err := json.Unmarshal([]byte(tt.entry), &e); err != nil {
+				t.Fatal(err)
+			}
+			if got := e.field("MESSAGE"); got != tt.want {
+				t.Errorf("field = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournalEntryLines(t *testing.T) {
+	usec := time.Date(2025, 11, 5, 14, 30, 16, 0, time.Local).UnixMicro()
+	stamp := time.UnixMicro(usec).Format("2006-01-02T15:04:05-0700")
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   []string
+	}{
+		{
+			name: "single line",
+			fields: map[string]interface{}{
+				"__REALTIME_TIMESTAMP": itoa(usec), "_HOSTNAME": "pi",
+				"SYSLOG_IDENTIFIER": "sshd", "_PID": "812", "MESSAGE": "Accepted key",
+			},
+			want: []string{stamp + " pi sshd[812]: Accepted key"},
+		},
+		{
+			name: "multi-line message",
+			fields: map[string]interface{}{
+				"__REALTIME_TIMESTAMP": itoa(usec), "_HOSTNAME": "pi",
+				"SYSLOG_IDENTIFIER": "app", "MESSAGE": "panic: boom\ngoroutine 1\n",
+			},
+			want: []string{
+				stamp + " pi app: panic: boom",
+				strings.Repeat(" ", len(stamp+" pi app: ")) + "goroutine 1",
+			},
+		},
+		{
+			name: "comm without identifier",
+			fields: map[string]interface{}{
+				"__REALTIME_TIMESTAMP": itoa(usec), "_HOSTNAME": "pi",
+				"_COMM": "cron", "MESSAGE": "tick",
+			},
+			want: []string{stamp + " pi cron: tick"},
+		},
+		{
+			name:   "no timestamp",
+			fields: map[string]interface{}{"_HOSTNAME": "pi", "SYSLOG_IDENTIFIER": "kernel", "MESSAGE": "usb"},
+			want:   []string{" pi kernel: usb"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := journalEntry(tt.fields).lines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournalEntryLinesTruncated(t *testing.T) {
+	e := journalEntry{"MESSAGE": strings.Repeat("x", maxLineLength+10)}
+	for _, line := range e.lines() {
+		if len(line) > maxLineLength {
+			t.Errorf("line of %d bytes exceeds %d", len(line), maxLineLength)
+		}
+	}
+}
+
+func TestJournalArgs(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	base := []string{"--output=json", "--no-pager"}
+
+	tests := []struct {
+		name    string
+		request ReadLogRequest
+		filter  lineFilter
+		want    []string // After base
+		wantErr bool
+	}{
+		{name: "defaults", want: nil},
+		{name: "unit", request: ReadLogRequest{Unit: "nginx.service"}, want: []string{"--unit=nginx.service"}},
+		{name: "template unit", request: ReadLogRequest{Unit: "getty@tty1.service"}, want: []string{"--unit=getty@tty1.service"}},
+		{name: "unit as option", request: ReadLogRequest{Unit: "--file=/etc/x"}, wantErr: true},
+		{name: "unit with space", request: ReadLogRequest{Unit: "a b"}, wantErr: true},
+		{name: "smart mode priority", request: ReadLogRequest{Mode: "smart"}, want: []string{"--priority=warning"}},
+		{name: "errors-only priority", request: ReadLogRequest{Mode: "errors-only"}, want: []string{"--priority=err"}},
+		{name: "full mode", request: ReadLogRequest{Mode: "full"}, want: nil},
+		{name: "explicit priority wins", request: ReadLogRequest{Mode: "smart", Priority: "crit"}, want: []string{"--priority=crit"}},
+		{name: "priority range", request: ReadLogRequest{Priority: "0..4"}, want: []string{"--priority=0..4"}},
+		{name: "invalid priority", request: ReadLogRequest{Priority: "8"}, wantErr: true},
+		{name: "priority injection", request: ReadLogRequest{Priority: "err --file=x"}, wantErr: true},
+		{name: "boot offset", request: ReadLogRequest{Boot: "-1"}, want: []string{"--boot=-1"}},
+		{name: "boot id", request: ReadLogRequest{Boot: strings.Repeat("ab", 16)}, want: []string{"--boot=" + strings.Repeat("ab", 16)}},
+		{name: "invalid boot", request: ReadLogRequest{Boot: "last"}, wantErr: true},
+		{name: "since", filter: lineFilter{since: since}, want: []string{"--since=@1700000000"}},
+		{name: "lines capped", request: ReadLogRequest{Lines: maxTailLines + 1}, want: []string{"--lines=" + itoa(maxTailLines)}},
+		{name: "follow", request: ReadLogRequest{Follow: true}, want: []string{"--lines=all", "--follow"}},
+		{name: "follow with lines", request: ReadLogRequest{Follow: true, Lines: 20}, want: []string{"--lines=20", "--follow"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := journalArgs(tt.request, tt.filter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("journalArgs = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(slices.Clone(base), tt.want...); !slices.Equal(got, want) {
+				t.Errorf("journalArgs = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func itoa[T int | int64](n T) string {
+	return strconv.FormatInt(int64(n), 10)
+}
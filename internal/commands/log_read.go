@@ -0,0 +1,393 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// logRead reads the sources of one read_log request into a stream
+type logRead struct {
+	lr      *LogReader
+	ctx     context.Context
+	sources []logSource // Newest first
+	order   string
+	filter  lineFilter
+	stream  *logStream
+
+	total   int64    // Size of all sources, for progress
+	done    int64    // Size of the sources already read
+	matched int      // Lines that passed the filter
+	recent  lineRing // Last unmatched lines of the newest source, for the fallback
+}
+
+// newLogRead prepares reading the sources in the given order
+func newLogRead(ctx context.Context, lr *LogReader, sources []logSource, order string, filter lineFilter, stream *logStream) *logRead {
+	r := &logRead{
+		lr:      lr,
+		ctx:     ctx,
+		sources: sources,
+		order:   order,
+		filter:  filter,
+		stream:  stream,
+		recent:  lineRing{size: filter.fallback},
+	}
+	for _, src := range sources {
+		r.total += src.info.Size()
+	}
+	return r
+}
+
+// ordered returns the first n sources in the requested order
+func (r *logRead) ordered(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	if r.order != OrderNewest {
+		slices.Reverse(idx)
+	}
+	return idx
+}
+
+// marker separates the files of a family, like tail does
+func (r *logRead) marker(src logSource) bool {
+	if len(r.sources) == 1 {
+		return true
+	}
+	return r.stream.add(fmt.Sprintf("==> %s <==", src.name))
+}
+
+// skip reports a family member that couldn't be read and carries on
+func (r *logRead) skip(src logSource, err error) bool {
+	r.lr.logger.Warn("Skipping log file", "path", src.path, "error", err, "session", r.stream.sessionID)
+	return r.stream.add(fmt.Sprintf("[skipped %s: %v]", src.name, err))
+}
+
+// progress updates the stream's progress from the bytes consumed of the
+// current source
+func (r *logRead) progress(consumed int64) {
+	if r.total > 0 {
+		r.stream.progress = min(float64(r.done+consumed)/float64(r.total)*100, 100)
+	}
+}
+
+// all streams every source from the start, filtering line by line. It
+// returns the offset up to which the newest source was read, follow mode
+// continues there.
+func (r *logRead) all() (int64, error) {
+	var offset int64
+	for _, i := range r.ordered(len(r.sources)) {
+		n, more, err := r.send(i, tailStart{end: -1})
+		if i == 0 {
+			offset = n
+		}
+		if err != nil {
+			return offset, err
+		}
+		if !more {
+			return offset, nil
+		}
+	}
+
+	if r.matched == 0 {
+		r.sendFallback(r.recent.lines())
+	}
+	return offset, nil
+}
+
+// send streams source i from the given start, preceded by its marker. A
+// family member that fails is reported and skipped.
+func (r *logRead) send(i int, from tailStart) (int64, bool, error) {
+	src := r.sources[i]
+	if !r.marker(src) {
+		return 0, false, nil
+	}
+	n, more, err := r.readSource(src, from, i == 0)
+	if err != nil && r.ctx.Err() == nil {
+		if len(r.sources) == 1 {
+			return n, false, err
+		}
+		more = r.skip(src, err)
+	}
+	r.done += src.info.Size()
+	return n, more, nil
+}
+
+// tailStart is where sending begins in a source
+type tailStart struct {
+	offset int64 // Plain files: byte offset of the first line
+	end    int64 // Plain files: offset to stop at, -1 = end of file
+	skip   int   // Archives: matches passed over before the first sent one
+}
+
+// readSource streams one source. It reports how much of it was read and
+// whether the transfer goes on.
+func (r *logRead) readSource(src logSource, from tailStart, newest bool) (int64, bool, error) {
+	rc, consumed, err := src.open(r.ctx, from.offset, from.end)
+	if err != nil {
+		return 0, true, err
+	}
+	reader := bufio.NewReaderSize(rc, 64*1024)
+	selector := newLineSelector(r.filter, r.stream.add)
+	selector.skip = from.skip
+	offset := from.offset
+
+	for {
+		line, n, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rc.Close()
+			return offset, true, err
+		}
+		offset += int64(n)
+		r.progress(from.offset + consumed.n)
+
+		matched, more := selector.feed(line)
+		if matched {
+			r.matched++
+		} else if newest {
+			r.recent.add(line)
+		}
+		if !more {
+			rc.Close()
+			return offset, false, nil
+		}
+	}
+	// A decompressor that failed reports it on close
+	return offset, true, rc.Close()
+}
+
+// tail streams the last n matching lines. It first finds, newest source
+// first, where they begin: plain files are scanned backwards, so only
+// their end is touched, archives are counted in a forward pass. Then the
+// lines are read forward from there, so context lines come out as usual.
+// No lines are held, archives only keep the sizes of their last n matches.
+func (r *logRead) tail(n int) error {
+	var (
+		found     int
+		used      int  // Sources looked at, newest first
+		first     = -1 // Oldest source with matches, sent from start
+		start     tailStart
+		budget    = r.stream.limit
+		truncated bool
+		recent    []string // Last lines of the newest source, newest first
+		errs      = map[int]error{}
+	)
+	if budget == 0 {
+		budget = -1
+	}
+	for i, src := range r.sources {
+		if found == n || truncated || r.ctx.Err() != nil {
+			break
+		}
+		var (
+			loc tailLocation
+			err error
+		)
+		if src.codec == "" {
+			loc, err = r.locatePlain(src, n-found, budget)
+		} else {
+			loc, err = r.locateArchive(src, n-found, budget)
+		}
+		used = i + 1
+		r.done += src.info.Size()
+		r.progress(0)
+		if err != nil {
+			if len(r.sources) == 1 {
+				return err
+			}
+			errs[i] = err
+			continue
+		}
+		if i == 0 {
+			recent = loc.recent
+		}
+		if loc.found > 0 {
+			first = i
+			start = loc.start
+		}
+		found += loc.found
+		if budget >= 0 {
+			budget -= loc.bytes
+		}
+		truncated = loc.truncated
+	}
+	if r.ctx.Err() != nil {
+		return nil
+	}
+
+	// Newer sources are sent completely, the oldest one from its start.
+	// Plain files end where they were scanned, follow takes over there.
+	r.done = 0
+	r.total = 0
+	for _, src := range r.sources[:max(first+1, 0)] {
+		r.total += src.info.Size()
+	}
+	for _, i := range r.ordered(used) {
+		src := r.sources[i]
+		if err, failed := errs[i]; failed {
+			if !r.marker(src) || !r.skip(src, err) {
+				return nil
+			}
+			continue
+		}
+		if i > first {
+			continue
+		}
+		from := tailStart{end: src.info.Size()}
+		if i == first {
+			from.offset = start.offset
+			from.skip = start.skip
+		}
+		_, more, err := r.send(i, from)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+
+	if found == 0 {
+		slices.Reverse(recent)
+		r.sendFallback(recent[max(len(recent)-n, 0):])
+		return nil
+	}
+	r.stream.truncated = r.stream.truncated || truncated
+	return nil
+}
+
+// tailLocation is what locating the last matches in one source found
+type tailLocation struct {
+	start     tailStart
+	found     int      // Matching lines from start on
+	bytes     int64    // Their size
+	truncated bool     // The output limit was reached before enough were found
+	recent    []string // Last lines, newest first, for the fallback
+}
+
+// locatePlain scans a plain file backwards for the line where its last
+// need matches (and their before context) begin. A budget of -1 means
+// no output limit.
+func (r *logRead) locatePlain(src logSource, need int, budget int64) (tailLocation, error) {
+	var loc tailLocation
+	file, _, err := openRegular(src.path)
+	if err != nil {
+		return loc, err
+	}
+	defer file.Close()
+
+	matcher := r.filter.matcher()
+	contextLeft := -1 // Lines still to include once need is reached
+	err = scanBackward(file, src.info.Size(), func(line string, offset int64) bool {
+		if r.ctx.Err() != nil {
+			return false
+		}
+		if len(loc.recent) < r.filter.fallback {
+			loc.recent = append(loc.recent, line)
+		}
+		if contextLeft >= 0 {
+			if contextLeft == 0 {
+				return false
+			}
+			contextLeft--
+			loc.start.offset = offset
+			return true
+		}
+		if !matcher.match(line) {
+			return true
+		}
+		// Older lines beyond the limit would be cut anyway
+		size := int64(len(line)) + 1
+		if budget >= 0 && loc.bytes+size > budget {
+			loc.truncated = true
+			return false
+		}
+		loc.bytes += size
+		loc.found++
+		loc.start.offset = offset
+		if loc.found == need {
+			contextLeft = r.filter.before
+		}
+		return true
+	})
+	if loc.found == 0 {
+		loc.start.offset = 0
+	}
+	return loc, err
+}
+
+// locateArchive counts the matches of an archive in a forward pass and
+// determines how many to pass over to send only the last need
+func (r *logRead) locateArchive(src logSource, need int, budget int64) (tailLocation, error) {
+	var loc tailLocation
+	rc, _, err := src.open(r.ctx, 0, -1)
+	if err != nil {
+		return loc, err
+	}
+	reader := bufio.NewReaderSize(rc, 64*1024)
+	matcher := r.filter.matcher()
+	sizes := make([]int64, 0, min(need, 1024)) // Sizes of the last need matches, a ring
+	next := 0
+	last := lineRing{size: r.filter.fallback}
+	total := 0
+	for r.ctx.Err() == nil {
+		line, _, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rc.Close()
+			return loc, err
+		}
+		last.add(line)
+		if !matcher.match(line) {
+			continue
+		}
+		total++
+		size := int64(len(line)) + 1
+		if len(sizes) < need {
+			sizes = append(sizes, size)
+		} else {
+			sizes[next] = size
+			next = (next + 1) % need
+		}
+	}
+	if err := rc.Close(); err != nil && r.ctx.Err() == nil {
+		return loc, err
+	}
+
+	// Newest matches first, as many as fit the limit
+	for i := 0; i < len(sizes); i++ {
+		size := sizes[(next+len(sizes)-1-i)%len(sizes)]
+		if budget >= 0 && loc.bytes+size > budget {
+			loc.truncated = true
+			break
+		}
+		loc.bytes += size
+		loc.found++
+	}
+	loc.start.skip = total - loc.found
+	loc.recent = last.lines()
+	slices.Reverse(loc.recent)
+	return loc, nil
+}
+
+// sendFallback sends the filter's replacement output when no line matched
+func (r *logRead) sendFallback(recent []string) {
+	r.stream.progress = 100
+	if r.filter.notice != "" {
+		r.stream.add(r.filter.notice)
+		return
+	}
+	for _, line := range recent {
+		if !r.stream.add(line) {
+			return
+		}
+	}
+}
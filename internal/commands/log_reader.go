@@ -1,22 +1,37 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"strings"
+	"syscall"
 	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
+	"github.com/javafleet/fleet-mate-linux/internal/pathacl"
 )
 
 // LogReader handles log file reading and streaming
 type LogReader struct {
-	MateID string
+	MateID    string
+	MaxOutput int64 // Bytes per transfer, 0 = unlimited
+	Journal   bool  // Reading the systemd journal is allowed
+	access    *pathacl.List
+	journal   *audit.Journal
+	logger    *slog.Logger
 }
 
-// NewLogReader creates a new log reader
-func NewLogReader(mateID string) *LogReader {
+// NewLogReader creates a new log reader restricted to the paths in access.
+// A nil journal disables auditing.
+func NewLogReader(mateID string, access *pathacl.List, journal *audit.Journal) *LogReader {
 	return &LogReader{
-		MateID: mateID,
+		MateID:  mateID,
+		access:  access,
+		journal: journal,
+		logger:  logging.For("commands"),
 	}
 }
 
@@ -25,7 +40,26 @@ type ReadLogRequest struct {
 	SessionID string `json:"sessionId"` // Session ID from Navigator
 	Path      string `json:"path"`
 	Mode      string `json:"mode"`  // "smart", "full", "errors-only"
-	Lines     int    `json:"lines"` // For future use
+	Lines     int    `json:"lines"` // Last N matching lines, 0 = whole file
+	Order     string `json:"order"` // Files of a family: OrderOldest or OrderNewest
+
+	Follow      bool `json:"follow"`      // Keep sending appended lines (tail -f)
+	IdleTimeout int  `json:"idleTimeout"` // Seconds without new lines until follow ends, 0 = default, at most an hour
+
+	// Filters, applied on top of Mode
+	Include    string `json:"include"`    // Regular expression lines must match
+	Exclude    string `json:"exclude"`    // Regular expression lines must not match
+	IgnoreCase bool   `json:"ignoreCase"` // For include, exclude and the mode keywords
+	Since      string `json:"since"`      // Time range, see parseTimeArg
+	Until      string `json:"until"`
+	Before     int    `json:"before"` // Context lines before a match (grep -B)
+	After      int    `json:"after"`  // Context lines after a match (grep -A)
+
+	// Journal source
+	Source   string `json:"source"`   // SourceFile (default) or SourceJournal
+	Unit     string `json:"unit"`     // systemd unit, e.g. "nginx.service"
+	Priority string `json:"priority"` // "err", "0..4", ...; default derived from Mode
+	Boot     string `json:"boot"`     // Boot ID or offset ("0", "-1"), empty = all boots
 }
 
 // LogDataMessage represents a log data chunk message
@@ -39,236 +73,205 @@ type LogDataMessage struct {
 	TotalChunks int     `json:"totalChunks"` // Total number of chunks
 }
 
+// LogErrorMessage reports a refused or unreadable log file
+type LogErrorMessage struct {
+	SessionID string `json:"sessionId"`
+	Path      string `json:"path"`
+	Error     string `json:"error"`
+	Status    string `json:"status"` // StatusRejected or StatusFailed
+}
+
 // LogCompleteMessage represents the log completion message
 type LogCompleteMessage struct {
 	SessionID string `json:"sessionId"`
 	TotalSize int    `json:"totalSize"`
+	Status    string `json:"status"`              // StatusCompleted, StatusCancelled or StatusIdle
+	Truncated bool   `json:"truncated,omitempty"` // Transfer exceeded the session limit
 }
 
-// HandleReadLogCommand processes the read_log command with line-based streaming
-func (lr *LogReader) HandleReadLogCommand(request ReadLogRequest, sendMessage func(msgType string, data interface{})) error {
-	log.Printf("Reading log file: %s (mode: %s)", request.Path, request.Mode)
+// HandleReadLogCommand processes the read_log command with line-based streaming.
+// The file is read incrementally, memory use doesn't depend on its size.
+// A pattern reads a log family including compressed rotations.
+// Cancelling ctx stops the transfer after the current chunk.
+func (lr *LogReader) HandleReadLogCommand(ctx context.Context, request ReadLogRequest, sendMessage func(msgType string, data interface{})) error {
+	lr.logger.Info("Reading log file", "path", request.Path, "mode", request.Mode)
+	started := time.Now()
+	entry := audit.Entry{
+		SessionID: request.SessionID,
+		Type:      "read_log",
+		Path:      request.Path,
+		Decision:  audit.Allowed,
+	}
 
-	// Read log file
-	content, err := os.ReadFile(request.Path)
-	if err != nil {
+	fail := func(status string, err error) error {
+		sendMessage("log_error", LogErrorMessage{
+			SessionID: request.SessionID,
+			Path:      request.Path,
+			Error:     err.Error(),
+			Status:    status,
+		})
+		if status == StatusRejected {
+			entry.Decision = audit.Rejected
+			lr.logger.Warn("Security: log access refused", "path", request.Path, "error", err, "session", request.SessionID)
+		}
+		entry.Status = status
+		entry.Reason = err.Error()
+		lr.journal.Record(entry)
 		return fmt.Errorf("failed to read log file: %w", err)
 	}
 
+	filter, err := newLineFilter(request, time.Now())
+	if err != nil {
+		return fail(StatusRejected, err)
+	}
+
+	var (
+		sources []logSource
+		args    []string
+	)
+	switch request.Source {
+	case "", SourceFile:
+		sources, err = lr.sources(request.Path)
+		if errors.As(err, new(rejectedError)) {
+			return fail(StatusRejected, err)
+		}
+		if err != nil {
+			return fail(StatusFailed, err)
+		}
+		if len(sources) == 1 {
+			entry.Path = sources[0].path
+		}
+		if request.Follow && sources[0].codec != "" {
+			return fail(StatusRejected, fmt.Errorf("%s is compressed and can't be followed", sources[0].name))
+		}
+	case SourceJournal:
+		if !lr.Journal {
+			return fail(StatusRejected, errors.New("reading the systemd journal is disabled"))
+		}
+		if args, err = journalArgs(request, filter); err != nil {
+			return fail(StatusRejected, err)
+		}
+		entry.Path = SourceJournal
+		entry.Args = args
+	default:
+		return fail(StatusRejected, fmt.Errorf("unknown log source %q", request.Source))
+	}
+
 	// Use session ID from Navigator (or generate if not provided for backwards compatibility)
 	sessionID := request.SessionID
 	if sessionID == "" {
 		sessionID = fmt.Sprintf("%s-%d", lr.MateID, time.Now().UnixMilli())
-		log.Printf("Warning: No sessionId provided, generated: %s", sessionID)
+		lr.logger.Warn("No sessionId provided, generated one", "session", sessionID)
 	}
 
-	// Split into lines
-	allLines := strings.Split(string(content), "\n")
-	totalLines := len(allLines)
-
-	log.Printf("Log file loaded: %d lines, sessionId: %s", totalLines, sessionID)
-
-	// Apply filtering based on mode
-	var linesToProcess []string
-	switch request.Mode {
-	case "smart":
-		linesToProcess = lr.filterRelevantLinesList(allLines)
-	case "errors-only":
-		linesToProcess = lr.filterErrorsOnlyList(allLines)
-	default: // "full" mode
-		linesToProcess = allLines
+	stream := &logStream{
+		ctx:         ctx,
+		sessionID:   sessionID,
+		limit:       lr.MaxOutput,
+		sendMessage: sendMessage,
+	}
+	var status string
+	if request.Source == SourceJournal {
+		status, err = lr.readJournal(ctx, args, request, filter, stream)
+	} else {
+		status, err = lr.readFiles(ctx, sources, request, filter, stream)
+	}
+	if err != nil {
+		return fail(StatusFailed, err)
 	}
 
-	log.Printf("After filtering: %d lines", len(linesToProcess))
-
-	// Stream in line-based chunks (1000 lines per chunk for LLM context)
-	linesPerChunk := 1000
-	totalChunks := (len(linesToProcess) + linesPerChunk - 1) / linesPerChunk
-
-	for chunkNum := 0; chunkNum < totalChunks; chunkNum++ {
-		start := chunkNum * linesPerChunk
-		end := start + linesPerChunk
-		if end > len(linesToProcess) {
-			end = len(linesToProcess)
-		}
-
-		chunkLines := linesToProcess[start:end]
-		chunk := strings.Join(chunkLines, "\n")
-		progress := float64(end) / float64(len(linesToProcess)) * 100
-
-		// Send chunk via WebSocket
-		sendMessage("log_data", LogDataMessage{
-			SessionID:   sessionID,
-			Chunk:       chunk,
-			Progress:    progress,
-			CurrentLine: end,
-			TotalLines:  len(linesToProcess),
-			ChunkNumber: chunkNum + 1,
-			TotalChunks: totalChunks,
-		})
-
-		log.Printf("Sent chunk %d/%d: lines %d-%d (%.1f%%)",
-			chunkNum+1, totalChunks, start+1, end, progress)
-
-		// Small delay between chunks to prevent overwhelming the connection
-		time.Sleep(10 * time.Millisecond)
+	switch {
+	case ctx.Err() != nil:
+		status = StatusCancelled
+		lr.logger.Info("Log transfer cancelled", "session", sessionID, "lines", stream.lines)
+	default:
+		stream.flush(true)
+		lr.logger.Info("Log transfer completed", "session", sessionID, "status", status,
+			"lines", stream.lines, "chunks", stream.chunks)
+	}
+	if stream.truncated {
+		lr.logger.Warn("Log transfer truncated", "session", sessionID, "bytes", stream.bytes, "limit", lr.MaxOutput)
 	}
 
 	// Send completion message
 	sendMessage("log_complete", LogCompleteMessage{
 		SessionID: sessionID,
-		TotalSize: len(linesToProcess),
+		TotalSize: stream.lines,
+		Status:    status,
+		Truncated: stream.truncated,
 	})
 
-	log.Printf("Log transfer completed: session=%s, %d lines in %d chunks",
-		sessionID, len(linesToProcess), totalChunks)
+	entry.Status = status
+	entry.Bytes = stream.bytes
+	if stream.truncated {
+		entry.Reason = fmt.Sprintf("log truncated after %d bytes", lr.MaxOutput)
+	}
+	entry.DurationMs = time.Since(started).Milliseconds()
+	lr.journal.Record(entry)
 	return nil
 }
 
-// filterRelevantLines filters log content for relevant entries (smart mode)
-func (lr *LogReader) filterRelevantLines(content string) string {
-	lines := strings.Split(content, "\n")
-	relevant := []string{}
-
-	// Keywords that indicate important log entries
-	keywords := []string{
-		"error", "ERROR", "Error",
-		"warn", "WARN", "warning", "Warning",
-		"fail", "FAIL", "failed", "Failed",
-		"critical", "CRITICAL", "Critical",
-		"panic", "Panic", "PANIC",
-		"segfault", "segmentation fault",
-		"out of memory", "OOM", "oom",
-		"authentication failure", "auth failed",
-		"denied", "Denied", "DENIED",
-		"timeout", "Timeout", "TIMEOUT",
-		"refused", "Refused", "REFUSED",
-		"exception", "Exception", "EXCEPTION",
+// readFiles reads the sources in the requested way and returns the final
+// status
+func (lr *LogReader) readFiles(ctx context.Context, sources []logSource, request ReadLogRequest, filter lineFilter, stream *logStream) (string, error) {
+	read := newLogRead(ctx, lr, sources, request.Order, filter, stream)
+	newest := sources[0]
+	offset := newest.info.Size()
+	var err error
+	if request.Lines > 0 {
+		err = read.tail(min(request.Lines, maxTailLines))
+	} else {
+		offset, err = read.all()
 	}
-
-	for _, line := range lines {
-		for _, keyword := range keywords {
-			if strings.Contains(line, keyword) {
-				relevant = append(relevant, line)
-				break
-			}
-		}
+	if err != nil {
+		return StatusFailed, err
 	}
 
-	// If filtering resulted in empty output, return at least some context
-	if len(relevant) == 0 {
-		// Return last 50 lines as fallback
-		startIdx := len(lines) - 50
-		if startIdx < 0 {
-			startIdx = 0
-		}
-		return strings.Join(lines[startIdx:], "\n")
+	if request.Follow && ctx.Err() == nil && !stream.truncated {
+		return lr.follow(newest, offset, request.IdleTimeout, filter, stream)
 	}
-
-	return strings.Join(relevant, "\n")
+	return StatusCompleted, nil
 }
 
-// filterErrorsOnly filters only error-level entries
-func (lr *LogReader) filterErrorsOnly(content string) string {
-	lines := strings.Split(content, "\n")
-	errors := []string{}
-
-	// Only critical keywords
-	keywords := []string{
-		"error", "ERROR", "Error",
-		"critical", "CRITICAL", "Critical",
-		"panic", "Panic", "PANIC",
-		"fail", "FAIL", "failed", "Failed",
-		"segfault", "segmentation fault",
-		"exception", "Exception", "EXCEPTION",
-	}
+// follow continues reading the newest source as lines are appended
+func (lr *LogReader) follow(src logSource, offset int64, idleTimeout int, filter lineFilter, stream *logStream) (string, error) {
+	idle := followIdle(idleTimeout)
 
-	for _, line := range lines {
-		for _, keyword := range keywords {
-			if strings.Contains(line, keyword) {
-				errors = append(errors, line)
-				break
-			}
-		}
+	file, _, err := openRegular(src.path)
+	if err != nil {
+		return StatusFailed, err
 	}
-
-	// If no errors found, return empty with notice
-	if len(errors) == 0 {
-		return "No errors found in log file."
+	f := &logFollower{
+		lr:        lr,
+		requested: src.name,
+		file:      file,
+		offset:    offset,
+		selector:  newLineSelector(filter, stream.add),
+		stream:    stream,
 	}
-
-	return strings.Join(errors, "\n")
+	defer func() { f.file.Close() }()
+	return f.run(idle)
 }
 
-// filterRelevantLinesList filters relevant lines (list-based for streaming)
-func (lr *LogReader) filterRelevantLinesList(lines []string) []string {
-	relevant := []string{}
-
-	// Keywords that indicate important log entries
-	keywords := []string{
-		"error", "ERROR", "Error",
-		"warn", "WARN", "warning", "Warning",
-		"fail", "FAIL", "failed", "Failed",
-		"critical", "CRITICAL", "Critical",
-		"panic", "Panic", "PANIC",
-		"segfault", "segmentation fault",
-		"out of memory", "OOM", "oom",
-		"authentication failure", "auth failed",
-		"denied", "Denied", "DENIED",
-		"timeout", "Timeout", "TIMEOUT",
-		"refused", "Refused", "REFUSED",
-		"exception", "Exception", "EXCEPTION",
+// openRegular opens a file for reading, refusing anything but regular
+// files. The type is checked on the open file, so it can't be swapped for
+// a FIFO or device in between.
+func openRegular(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	for _, line := range lines {
-		for _, keyword := range keywords {
-			if strings.Contains(line, keyword) {
-				relevant = append(relevant, line)
-				break
-			}
-		}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
 	}
-
-	// If filtering resulted in empty output, return at least some context
-	if len(relevant) == 0 {
-		// Return last 50 lines as fallback
-		startIdx := len(lines) - 50
-		if startIdx < 0 {
-			startIdx = 0
-		}
-		return lines[startIdx:]
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s is %w", path, errNotRegular)
 	}
-
-	return relevant
+	return file, info, nil
 }
 
-// filterErrorsOnlyList filters only error-level entries (list-based for streaming)
-func (lr *LogReader) filterErrorsOnlyList(lines []string) []string {
-	errors := []string{}
-
-	// Only critical keywords
-	keywords := []string{
-		"error", "ERROR", "Error",
-		"critical", "CRITICAL", "Critical",
-		"panic", "Panic", "PANIC",
-		"fail", "FAIL", "failed", "Failed",
-		"segfault", "segmentation fault",
-		"exception", "Exception", "EXCEPTION",
-	}
-
-	for _, line := range lines {
-		for _, keyword := range keywords {
-			if strings.Contains(line, keyword) {
-				errors = append(errors, line)
-				break
-			}
-		}
-	}
-
-	// If no errors found, return notice
-	if len(errors) == 0 {
-		return []string{"No errors found in log file."}
-	}
-
-	return errors
-}
+// errNotRegular refuses directories, devices, FIFOs and sockets
+var errNotRegular = errors.New("not a regular file")
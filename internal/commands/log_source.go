@@ -0,0 +1,217 @@
+package commands
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxFamilyFiles caps the files a log family pattern may select
+const maxFamilyFiles = 100
+
+// Log orders for a family
+const (
+	OrderOldest = "oldest" // Oldest file first, the default
+	OrderNewest = "newest" // Newest file first
+)
+
+// logSource is one file of a read_log request
+type logSource struct {
+	name  string // Path as requested or matched
+	path  string // Resolved path that gets read
+	info  os.FileInfo
+	codec string // Decompressor, empty for plain text
+}
+
+// codecs maps archive suffixes to their decompressor. gzip and bzip2 are
+// built in, xz and zstd need the command line tools.
+var codecs = map[string]string{
+	".gz":  "gzip",
+	".bz2": "bzip2",
+	".xz":  "xz",
+	".zst": "zstd",
+}
+
+// rejectedError marks errors that refuse access rather than fail
+type rejectedError struct {
+	error
+}
+
+func (e rejectedError) Unwrap() error { return e.error }
+
+// sources resolves the requested path. A pattern ("/var/log/syslog*")
+// selects a log family: every matching file that is allowed, newest first.
+// Only patterns inside the allowed roots are expanded, and refused matches
+// are never named, so a pattern can't list other directories.
+func (lr *LogReader) sources(requested string) ([]logSource, error) {
+	if !strings.ContainsAny(requested, "*?[") {
+		src, err := lr.source(requested)
+		if err != nil {
+			return nil, err
+		}
+		return []logSource{src}, nil
+	}
+
+	if !filepath.IsAbs(requested) {
+		return nil, rejectedError{fmt.Errorf("path %s must be absolute", requested)}
+	}
+	if !lr.access.Walkable(requested) {
+		return nil, rejectedError{fmt.Errorf("access to %s is not allowed", requested)}
+	}
+	matches, err := filepath.Glob(requested)
+	if err != nil {
+		return nil, rejectedError{fmt.Errorf("invalid pattern %q", requested)}
+	}
+
+	var (
+		family   []logSource
+		resolved = map[string]bool{}
+	)
+	for _, match := range matches {
+		src, err := lr.source(match)
+		if err != nil {
+			// Denied, vanished or no regular file: not part of the family
+			lr.logger.Debug("Skipping log file", "path", match, "error", err)
+			continue
+		}
+		if resolved[src.path] {
+			continue
+		}
+		resolved[src.path] = true
+		family = append(family, src)
+	}
+
+	switch {
+	case len(family) == 0:
+		return nil, fmt.Errorf("no allowed log files match %s", requested)
+	case len(family) > maxFamilyFiles:
+		return nil, rejectedError{fmt.Errorf("%s matches %d files, at most %d are allowed", requested, len(family), maxFamilyFiles)}
+	}
+
+	// Rotated files keep their modification time, so it orders both
+	// numbered (syslog.2.gz) and dated (syslog-20250101.gz) rotation
+	sort.SliceStable(family, func(i, j int) bool {
+		ti, tj := family[i].info.ModTime(), family[j].info.ModTime()
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return generation(family[i].name) < generation(family[j].name)
+	})
+	return family, nil
+}
+
+// source checks one file. Symlinks and ".." are resolved before the
+// allowlist check, the resolved path is what gets read.
+func (lr *LogReader) source(name string) (logSource, error) {
+	path, err := lr.access.Resolve(name)
+	if err != nil {
+		return logSource{}, rejectedError{err}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return logSource{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return logSource{}, rejectedError{fmt.Errorf("%s is %w", path, errNotRegular)}
+	}
+	return logSource{name: name, path: path, info: info, codec: codecs[filepath.Ext(path)]}, nil
+}
+
+// generation returns the rotation number of syslog.2.gz, 0 for the
+// current file
+func generation(name string) int {
+	if _, ok := codecs[filepath.Ext(name)]; ok {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Ext(name), "."))
+	return n
+}
+
+// open opens a source for reading, archives are decompressed on the fly.
+// Plain files are read from offset up to end (-1 = to the end), archives
+// always completely. consumed counts the bytes read from the file itself,
+// for progress.
+func (src logSource) open(ctx context.Context, offset, end int64) (r io.ReadCloser, consumed *countingReader, err error) {
+	file, _, err := openRegular(src.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	consumed = &countingReader{r: file}
+
+	switch src.codec {
+	case "":
+		if end >= 0 {
+			consumed.r = io.NewSectionReader(file, offset, end-offset)
+		} else if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return readCloser{consumed, file.Close}, consumed, nil
+	case "gzip":
+		gz, err := gzip.NewReader(consumed)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("gzip: %w", err)
+		}
+		return readCloser{gz, file.Close}, consumed, nil
+	case "bzip2":
+		return readCloser{bzip2.NewReader(consumed), file.Close}, consumed, nil
+	}
+
+	// xz and zstd: the tool reads the already opened file on stdin
+	tool, err := exec.LookPath(src.codec)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s is not installed", src.codec)
+	}
+	cmd := exec.CommandContext(ctx, tool, "-dc")
+	cmd.Stdin = consumed
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return readCloser{out, func() error {
+		out.Close()
+		err := cmd.Wait()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", src.codec, err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}}, consumed, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readCloser pairs a decompressor with the close of its source
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
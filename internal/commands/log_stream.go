@@ -0,0 +1,215 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// linesPerChunk keeps a log_data message small enough for LLM context
+	linesPerChunk = 1000
+
+	// maxLogChunkSize caps the content of a single log_data message
+	maxLogChunkSize = 1024 * 1024
+
+	// maxLineLength cuts longer lines, so a file without newlines can't
+	// exhaust memory
+	maxLineLength = 64 * 1024
+
+	// maxTailLines caps the Lines field of read_log
+	maxTailLines = 100000
+
+	// tailBlockSize is read per step when scanning a file backwards
+	tailBlockSize = 64 * 1024
+)
+
+// logStream batches log lines into log_data chunks and enforces the
+// session's output limit. Totals are only known once the source is
+// exhausted, so intermediate chunks report TotalLines and TotalChunks as 0.
+type logStream struct {
+	ctx         context.Context
+	sessionID   string
+	limit       int64 // Bytes sent at most, 0 = unlimited
+	sendMessage func(msgType string, data interface{})
+
+	chunk      []string
+	chunkBytes int
+	progress   float64 // Set by the reader, percent of the source consumed
+	lines      int     // Lines sent so far
+	bytes      int64   // Content bytes sent so far
+	chunks     int
+	truncated  bool
+}
+
+// add queues a line for the next chunk. It reports false once the
+// transfer has to stop because ctx is done or the limit is reached.
+func (s *logStream) add(line string) bool {
+	if s.truncated || s.ctx.Err() != nil {
+		return false
+	}
+
+	size := len(line)
+	if len(s.chunk) > 0 {
+		size++ // separating newline
+	}
+	if s.limit > 0 && s.bytes+int64(s.chunkBytes+size) > s.limit {
+		s.truncated = true
+		s.flush(false)
+		return false
+	}
+
+	s.chunk = append(s.chunk, line)
+	s.chunkBytes += size
+	if len(s.chunk) >= linesPerChunk || s.chunkBytes >= maxLogChunkSize {
+		s.flush(false)
+		// Small delay between chunks to prevent overwhelming the connection
+		select {
+		case <-s.ctx.Done():
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// flush sends the queued lines. The last chunk carries the totals, a
+// truncated one the truncation marker.
+func (s *logStream) flush(last bool) {
+	if len(s.chunk) == 0 && !s.truncated {
+		return
+	}
+
+	content := strings.Join(s.chunk, "\n")
+	s.lines += len(s.chunk)
+	s.bytes += int64(len(content))
+	s.chunks++
+	if s.truncated {
+		content += fmt.Sprintf("\n[log truncated after %d bytes]", s.bytes)
+		last = true
+	}
+
+	msg := LogDataMessage{
+		SessionID:   s.sessionID,
+		Chunk:       content,
+		Progress:    s.progress,
+		CurrentLine: s.lines,
+		ChunkNumber: s.chunks,
+	}
+	if last {
+		msg.TotalLines = s.lines
+		msg.TotalChunks = s.chunks
+	}
+	s.sendMessage("log_data", msg)
+	s.chunk = s.chunk[:0]
+	s.chunkBytes = 0
+}
+
+// lineRing keeps the last size lines added
+type lineRing struct {
+	size int
+	buf  []string
+	next int // Oldest entry once buf is full
+}
+
+// add appends a line, dropping the oldest when full
+func (r *lineRing) add(line string) {
+	switch {
+	case r.size <= 0:
+	case len(r.buf) < r.size:
+		r.buf = append(r.buf, line)
+	default:
+		r.buf[r.next] = line
+		r.next = (r.next + 1) % r.size
+	}
+}
+
+// lines returns the kept lines, oldest first
+func (r *lineRing) lines() []string {
+	return append(slices.Clone(r.buf[r.next:]), r.buf[:r.next]...)
+}
+
+// readLine returns the next line without its newline and the number of
+// bytes consumed. Lines longer than maxLineLength are cut.
+func readLine(r *bufio.Reader) (string, int, error) {
+	var line []byte
+	n := 0
+	for {
+		frag, err := r.ReadSlice('\n')
+		n += len(frag)
+		if room := maxLineLength - len(line); room > 0 {
+			if len(frag) > room {
+				line = append(line, frag[:room]...)
+			} else {
+				line = append(line, frag...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && (err != io.EOF || n == 0) {
+			return "", n, err
+		}
+		return string(bytes.TrimSuffix(line, []byte("\n"))), n, nil
+	}
+}
+
+// scanBackward calls fn for every line of r with its start offset, the
+// last line first, until fn returns false. Only one block and one partial
+// line are held in memory.
+func scanBackward(r io.ReaderAt, size int64, fn func(line string, offset int64) bool) error {
+	var rest []byte // start of a line whose beginning isn't read yet
+	last := true    // a final newline doesn't start another line
+	emit := func(line []byte, offset int64) bool {
+		if last {
+			last = false
+			if len(line) == 0 {
+				return true
+			}
+		}
+		if len(line) > maxLineLength {
+			line = line[:maxLineLength]
+		}
+		return fn(string(line), offset)
+	}
+
+	for pos := size; pos > 0; {
+		n := int64(tailBlockSize)
+		if n > pos {
+			n = pos
+		}
+		pos -= n
+
+		data := make([]byte, n, n+int64(len(rest)))
+		if _, err := r.ReadAt(data, pos); err != nil && err != io.EOF {
+			return err
+		}
+		data = append(data, rest...)
+
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if !emit(data[i+1:], pos+int64(i)+1) {
+				return nil
+			}
+			data = data[:i]
+		}
+		// Lines are cut like readLine does, keeping their beginning
+		if len(data) > maxLineLength {
+			data = data[:maxLineLength]
+		}
+		rest = data
+	}
+
+	if size > 0 {
+		emit(rest, 0)
+	}
+	return nil
+}
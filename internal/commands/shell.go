@@ -0,0 +1,363 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
+)
+
+// Final shell states in addition to the command states
+const (
+	StatusClosed = "closed" // Closed by the Navigator with shell_close
+	StatusIdle   = "idle"   // No input (or new log lines) within the idle timeout
+	StatusFailed = "failed" // The shell could not be started
+)
+
+const (
+	defaultShellCols = 80
+	defaultShellRows = 24
+	defaultShellTerm = "xterm-256color"
+
+	// shellKillDelay is the grace period between SIGHUP and SIGKILL, and
+	// how long remaining output is read after the shell exited
+	shellKillDelay = 2 * time.Second
+
+	// shellInputQueue is the number of shell_input messages buffered
+	// while the shell doesn't read its terminal
+	shellInputQueue = 64
+)
+
+// ShellOpenRequest represents the shell_open payload
+type ShellOpenRequest struct {
+	SessionID  string `json:"sessionId"`
+	Shell      string `json:"shell"` // Shell binary, empty = default from the policy
+	User       string `json:"user"`  // Navigator user opening the shell
+	WorkingDir string `json:"workingDir"`
+	Cols       int    `json:"cols"`
+	Rows       int    `json:"rows"`
+	Term       string `json:"term"` // TERM for the shell, default xterm-256color
+}
+
+// ShellOpenedMessage confirms a started shell
+type ShellOpenedMessage struct {
+	SessionID   string `json:"sessionId"`
+	Shell       string `json:"shell"`
+	Pid         int    `json:"pid"`
+	IdleTimeout int    `json:"idleTimeout"` // seconds
+}
+
+// ShellOutputMessage carries terminal output, base64 encoded because it
+// is raw bytes (escape sequences, split UTF-8)
+type ShellOutputMessage struct {
+	SessionID string `json:"sessionId"`
+	Data      string `json:"data"`
+	Seq       int64  `json:"seq"`
+}
+
+// ShellExitMessage reports the end of a shell session
+type ShellExitMessage struct {
+	SessionID string `json:"sessionId"`
+	ExitCode  int    `json:"exitCode"`
+	Status    string `json:"status"` // StatusCompleted, StatusClosed, StatusIdle, StatusCancelled, StatusRejected or StatusFailed
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Shell is a running interactive shell on a pseudo-terminal
+type Shell struct {
+	sessionID string
+	cmd       *exec.Cmd
+	pty       *os.File
+
+	input     chan []byte
+	activity  chan struct{}
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// OpenShell starts an interactive shell if the policy allows it. Output is
+// streamed as shell_output until the shell exits, is closed, stays idle
+// or ctx is cancelled; the session always ends with shell_exit.
+func (ce *CommandExecutor) OpenShell(ctx context.Context, request ShellOpenRequest, sendMessage func(msgType string, data interface{})) (*Shell, error) {
+	ce.logger.Info("Opening shell", "shell", request.Shell, "user", request.User, "session", request.SessionID)
+	started := time.Now()
+
+	decision := ce.policy.CheckShell(request.Shell, request.User)
+	entry := audit.Entry{
+		SessionID: request.SessionID,
+		User:      request.User,
+		Type:      "shell_open",
+		Command:   decision.Command,
+		Path:      decision.Path,
+		Decision:  audit.Allowed,
+		Rule:      decision.Rule,
+		Reason:    decision.Reason,
+	}
+
+	fail := func(status, reason string) (*Shell, error) {
+		sendMessage("shell_exit", ShellExitMessage{
+			SessionID: request.SessionID,
+			ExitCode:  127,
+			Status:    status,
+			Reason:    reason,
+		})
+		entry.Status = status
+		entry.Reason = reason
+		ce.journal.Record(entry)
+		return nil, errors.New(reason)
+	}
+
+	if !decision.Allowed {
+		ce.logger.Warn("Security: shell rejected", "shell", decision.Command, "user", request.User,
+			"reason", decision.Reason, "session", request.SessionID)
+		entry.Decision = audit.Rejected
+		return fail(StatusRejected, decision.Reason)
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		return fail(StatusFailed, err.Error())
+	}
+	defer slave.Close()
+
+	cols, rows := request.Cols, request.Rows
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultShellCols, defaultShellRows
+	}
+	if err := setWinsize(master, cols, rows); err != nil {
+		master.Close()
+		return fail(StatusFailed, fmt.Sprintf("failed to set terminal size: %v", err))
+	}
+
+	// Start as login shell ("-bash") in a new session with the pty as
+	// controlling terminal, so job control and SIGHUP work as in ssh
+	cmd := exec.Command(decision.Path)
+	cmd.Args[0] = "-" + filepath.Base(decision.Path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if decision.Sandbox != nil {
+		decision.Sandbox.Apply(cmd)
+	}
+
+	term := request.Term
+	if term == "" {
+		term = defaultShellTerm
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TERM="+term)
+
+	cmd.Dir = "/"
+	if request.WorkingDir != "" {
+		cmd.Dir = request.WorkingDir
+	}
+
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return fail(StatusFailed, fmt.Sprintf("failed to start shell: %v", err))
+	}
+
+	s := &Shell{
+		sessionID: request.SessionID,
+		cmd:       cmd,
+		pty:       master,
+		input:     make(chan []byte, shellInputQueue),
+		activity:  make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	idleTimeout := ce.policy.Shell.IdleTimeout
+	sendMessage("shell_opened", ShellOpenedMessage{
+		SessionID:   request.SessionID,
+		Shell:       decision.Path,
+		Pid:         cmd.Process.Pid,
+		IdleTimeout: int(idleTimeout.Seconds()),
+	})
+	ce.logger.Info("Shell started", "shell", decision.Path, "pid", cmd.Process.Pid, "session", request.SessionID)
+
+	entry.Status = "opened"
+	ce.journal.Record(entry)
+
+	go s.writeInput()
+	go func() {
+		status, exitCode, sent := s.run(ctx, idleTimeout, sendMessage)
+
+		reason := ""
+		if status == StatusIdle {
+			reason = fmt.Sprintf("no input for %s", idleTimeout)
+		}
+		sendMessage("shell_exit", ShellExitMessage{
+			SessionID: request.SessionID,
+			ExitCode:  exitCode,
+			Status:    status,
+			Reason:    reason,
+		})
+
+		entry.Status = status
+		entry.Reason = reason
+		entry.ExitCode = &exitCode
+		entry.Bytes = sent
+		entry.DurationMs = time.Since(started).Milliseconds()
+		ce.journal.Record(entry)
+
+		ce.logger.Info("Shell ended", "session", request.SessionID, "status", status, "exit_code", exitCode)
+	}()
+
+	return s, nil
+}
+
+// Write queues input for the shell. It never blocks the caller.
+func (s *Shell) Write(data []byte) error {
+	select {
+	case <-s.done:
+		return errors.New("shell has exited")
+	default:
+	}
+
+	select {
+	case s.input <- data:
+	default:
+		return errors.New("shell input buffer is full")
+	}
+
+	select {
+	case s.activity <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Resize changes the terminal size, the shell receives SIGWINCH
+func (s *Shell) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	return setWinsize(s.pty, cols, rows)
+}
+
+// Close hangs up the shell
+func (s *Shell) Close() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// Done is closed when the shell session is over
+func (s *Shell) Done() <-chan struct{} {
+	return s.done
+}
+
+// writeInput copies queued input to the terminal
+func (s *Shell) writeInput() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.input:
+			if _, err := s.pty.Write(data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run streams output and waits until the shell is over. It returns the
+// final status, the exit code and the number of output bytes sent.
+func (s *Shell) run(ctx context.Context, idleTimeout time.Duration, sendMessage func(msgType string, data interface{})) (string, int, int64) {
+	defer close(s.done)
+
+	var sent int64
+	output := make(chan struct{})
+	go func() {
+		defer close(output)
+		buf := make([]byte, maxChunkSize)
+		var seq int64
+		for {
+			n, err := s.pty.Read(buf)
+			if n > 0 {
+				seq++
+				sent += int64(n)
+				sendMessage("shell_output", ShellOutputMessage{
+					SessionID: s.sessionID,
+					Data:      base64.StdEncoding.EncodeToString(buf[:n]),
+					Seq:       seq,
+				})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	exited := make(chan error, 1)
+	go func() { exited <- s.cmd.Wait() }()
+
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+
+	var status string
+	var err error
+	for status == "" {
+		select {
+		case err = <-exited:
+			status = StatusCompleted
+		case <-s.activity:
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(idleTimeout)
+		case <-idle.C:
+			status = StatusIdle
+		case <-s.closing:
+			status = StatusClosed
+		case <-ctx.Done():
+			status = StatusCancelled
+		}
+	}
+	if status != StatusCompleted {
+		err = s.hangup(exited)
+	}
+
+	// Background jobs may keep the terminal open, don't wait for them
+	select {
+	case <-output:
+	case <-time.After(shellKillDelay):
+	}
+	s.pty.Close()
+	<-output
+
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = 1
+	}
+	return status, exitCode, sent
+}
+
+// hangup sends SIGHUP to the shell's session and SIGKILL if it is still
+// running after the grace period
+func (s *Shell) hangup(exited <-chan error) error {
+	pid := s.cmd.Process.Pid
+	syscall.Kill(-pid, syscall.SIGHUP)
+
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(shellKillDelay):
+		syscall.Kill(-pid, syscall.SIGKILL)
+		return <-exited
+	}
+}
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// FlushLine sends complete lines as soon as they arrive
+	FlushLine = "line"
+	// FlushTime collects output and sends it every flush interval
+	FlushTime = "time"
+
+	// defaultFlushInterval is used when the request does not set one
+	defaultFlushInterval = 200 * time.Millisecond
+
+	// maxChunkSize caps the content of a single output message
+	maxChunkSize = 16 * 1024
+)
+
+// outputStreamer turns process output into sequence-numbered chunks.
+// stdout and stderr share one sequence so the Navigator can restore the
+// original interleaving.
+type outputStreamer struct {
+	sessionID   string
+	mode        string
+	limit       int64 // Output bytes forwarded at most, 0 = unlimited
+	sendMessage func(msgType string, data interface{})
+
+	mu        sync.Mutex // serializes sequence numbers and sends
+	seq       int64
+	bytes     int64 // Output sent so far, for the audit journal
+	truncated bool  // limit reached, further output is discarded
+	stdout    *chunkWriter
+	stderr    *chunkWriter
+}
+
+// newOutputStreamer creates a streamer for one command session
+func newOutputStreamer(sessionID, mode string, limit int64, sendMessage func(msgType string, data interface{})) *outputStreamer {
+	if mode != FlushTime {
+		mode = FlushLine
+	}
+	s := &outputStreamer{
+		sessionID:   sessionID,
+		mode:        mode,
+		limit:       limit,
+		sendMessage: sendMessage,
+	}
+	s.stdout = &chunkWriter{streamer: s, stream: "stdout", msgType: "command_output"}
+	s.stderr = &chunkWriter{streamer: s, stream: "stderr", msgType: "command_error"}
+	return s
+}
+
+// run flushes buffered output every interval until stop is closed
+func (s *outputStreamer) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.stdout.flush(true)
+			s.stderr.flush(true)
+		}
+	}
+}
+
+// close sends everything that is still buffered
+func (s *outputStreamer) close() {
+	s.stdout.flush(true)
+	s.stderr.flush(true)
+}
+
+// sent returns the number of output bytes sent and whether output was
+// truncated
+func (s *outputStreamer) sent() (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes, s.truncated
+}
+
+// send emits one chunk with the next sequence number. Once the limit is
+// reached, a truncation marker is sent and everything else is dropped.
+func (s *outputStreamer) send(msgType, stream, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.truncated {
+		return
+	}
+	if s.limit > 0 && s.bytes+int64(len(content)) > s.limit {
+		content = content[:s.limit-s.bytes]
+		s.truncated = true
+	}
+
+	if content != "" {
+		s.seq++
+		s.bytes += int64(len(content))
+		s.sendMessage(msgType, CommandOutputMessage{
+			SessionID: s.sessionID,
+			Content:   content,
+			Stream:    stream,
+			Seq:       s.seq,
+		})
+	}
+
+	if s.truncated {
+		s.sendMessage("command_error", CommandOutputMessage{
+			SessionID: s.sessionID,
+			Content:   fmt.Sprintf("\n[output truncated after %d bytes]\n", s.limit),
+		})
+	}
+}
+
+// chunkWriter buffers one output stream of a process
+type chunkWriter struct {
+	streamer *outputStreamer
+	stream   string
+	msgType  string
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer for exec.Cmd
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.buf.Write(p)
+	full := w.buf.Len() >= maxChunkSize
+	w.mu.Unlock()
+
+	switch {
+	case full:
+		w.flush(false)
+	case w.streamer.mode == FlushLine && bytes.IndexByte(p, '\n') >= 0:
+		w.flush(false)
+	}
+	return len(p), nil
+}
+
+// flush sends buffered output. Unless partial is set, a trailing
+// incomplete line is kept back for the next write or timer tick.
+func (w *chunkWriter) flush(partial bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for w.buf.Len() > 0 {
+		data := w.buf.Bytes()
+		n := len(data)
+		if n > maxChunkSize {
+			n = maxChunkSize
+			if i := bytes.LastIndexByte(data[:n], '\n'); i >= 0 {
+				n = i + 1
+			}
+		} else if !partial {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				return
+			}
+			n = i + 1
+		}
+
+		w.streamer.send(w.msgType, w.stream, string(data[:n]))
+		w.buf.Next(n)
+	}
+}
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/javafleet/fleet-mate-linux/internal/pathacl"
+	"github.com/javafleet/fleet-mate-linux/internal/policy"
 )
 
 // Config represents the application configuration
@@ -15,26 +20,86 @@ type Config struct {
 	Monitoring MonitoringConfig `yaml:"monitoring"`
 	Hardware   HardwareConfig   `yaml:"hardware"`
 	Logging    LoggingConfig    `yaml:"logging"`
+	Discovery  DiscoveryConfig  `yaml:"discovery"`
+	Commands   CommandsConfig   `yaml:"commands"`
+	Audit      AuditConfig      `yaml:"audit"`
+	Files      FilesConfig      `yaml:"files"`
+	Logs       LogsConfig       `yaml:"logs"`
 }
 
 // MateConfig contains mate identification
 type MateConfig struct {
-	ID          string `yaml:"id"`
+	ID          string `yaml:"id"` // Empty: generated and persisted in StateDir
 	Name        string `yaml:"name"`
 	Description string `yaml:"description"`
+	StateDir    string `yaml:"state_dir"`
+
+	// IDSource tells where ID came from (config, persisted, generated, ...).
+	// Set at startup by the identity package, never read from YAML.
+	IDSource string `yaml:"-"`
 }
 
 // NavigatorConfig contains Fleet Navigator connection settings
 type NavigatorConfig struct {
 	URL                   string        `yaml:"url"`
+	URLs                  []string      `yaml:"urls"`              // Failover list, takes precedence over URL
+	Selection             string        `yaml:"selection"`         // "priority" (default) or "round-robin"
+	FailbackInterval      time.Duration `yaml:"failback_interval"` // How often to probe the preferred endpoint
 	ReconnectInterval     time.Duration `yaml:"reconnect_interval"`
 	MaxReconnectAttempts  int           `yaml:"max_reconnect_attempts"`
+	ReconnectMaxInterval  time.Duration `yaml:"reconnect_max_interval"` // Upper bound for exponential backoff
+	ReconnectMultiplier   float64       `yaml:"reconnect_multiplier"`   // Backoff growth factor per failed attempt
+	ReconnectJitter       float64       `yaml:"reconnect_jitter"`       // Random spread, fraction of the delay (0-1)
+	ListenerTimeout       time.Duration `yaml:"listener_timeout"`       // Max wait for a discovery signal
+	TLS                   TLSConfig     `yaml:"tls"`
+	JoinToken             string        `yaml:"join_token"` // One-time enrollment token
+}
+
+// TLSConfig contains TLS settings for wss:// Navigator connections
+type TLSConfig struct {
+	CAFile     string   `yaml:"ca_file"`     // PEM bundle, replaces the system CAs
+	CertFile   string   `yaml:"cert_file"`   // Client certificate for mutual TLS
+	KeyFile    string   `yaml:"key_file"`    // Client private key for mutual TLS
+	ServerName string   `yaml:"server_name"` // Overrides the host name used for verification
+	PinnedKeys []string `yaml:"pinned_keys"` // SHA-256 of the server's SubjectPublicKeyInfo (hex or sha256/base64)
+}
+
+// DiscoveryConfig contains Navigator discovery settings
+type DiscoveryConfig struct {
+	Secret        string              `yaml:"secret"`         // Shared HMAC secret for announcements
+	AllowUnsigned bool                `yaml:"allow_unsigned"` // Accept legacy unsigned announcements (insecure)
+	UDP           UDPDiscoveryConfig  `yaml:"udp"`
+	MDNS          MDNSDiscoveryConfig `yaml:"mdns"`
+}
+
+// UDPDiscoveryConfig contains settings for the UDP broadcast listener
+type UDPDiscoveryConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	BindAddress string `yaml:"bind_address"`
+	Port        int    `yaml:"port"`
+}
+
+// MDNSDiscoveryConfig contains settings for DNS-SD browsing via multicast DNS
+type MDNSDiscoveryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Service  string        `yaml:"service"`  // DNS-SD service type, e.g. _fleet-navigator._tcp
+	Domain   string        `yaml:"domain"`   // Browse domain, normally local
+	Interval time.Duration `yaml:"interval"` // Time between browse queries
 }
 
 // MonitoringConfig contains monitoring settings
 type MonitoringConfig struct {
 	Interval time.Duration     `yaml:"interval"`
 	Enabled  MonitoringEnabled `yaml:"enabled"`
+	Buffer   BufferConfig      `yaml:"buffer"`
+}
+
+// BufferConfig controls the on-disk queue for stats collected while offline
+type BufferConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Dir     string        `yaml:"dir"`      // Default: <mate.state_dir>/spool
+	MaxSize int           `yaml:"max_size"` // megabytes
+	MaxAge  time.Duration `yaml:"max_age"`  // older samples are discarded
 }
 
 // MonitoringEnabled defines which monitors are active
@@ -91,45 +156,153 @@ type GPUConfig struct {
 	NvidiaOnly bool `yaml:"nvidia_only"` // Currently only NVIDIA is supported
 }
 
-// LoggingConfig contains logging settings
-type LoggingConfig struct {
-	Level      string `yaml:"level"`
-	File       string `yaml:"file"`
-	MaxSize    int    `yaml:"max_size"`
-	MaxBackups int    `yaml:"max_backups"`
-	MaxAge     int    `yaml:"max_age"`
+// CommandsConfig contains settings for remote commands
+type CommandsConfig struct {
+	PolicyFile    string         `yaml:"policy_file"`     // Command policy, empty uses the built-in policy
+	MaxSessions   int            `yaml:"max_sessions"`    // Concurrent execute_command, read_log and file_download sessions, follows excluded
+	QueueSize     int            `yaml:"queue_size"`      // Sessions waiting for a free slot, more are rejected as busy
+	MaxOutputSize int            `yaml:"max_output_size"` // MB of output per session, 0 = unlimited
+	MaxShells     int            `yaml:"max_shells"`      // Concurrent shell_open sessions, outside max_sessions; 0 = shells disabled
+	Policy        *policy.Policy `yaml:"-"`               // Loaded from PolicyFile at startup and reload
 }
 
-// Load reads and parses the configuration file
-func Load(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
+// AuditConfig contains settings for the audit journal
+type AuditConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"` // default: <state_dir>/audit.jsonl
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
-	}
+	MaxSize  int `yaml:"max_size"`  // MB per file before rotation, 0 = never rotate
+	MaxFiles int `yaml:"max_files"` // Rotated files to keep, 0 = all
+}
 
-	// Validate configuration
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
-	}
+// FilesConfig contains settings for file_download and file_upload
+type FilesConfig struct {
+	Allow   []string `yaml:"allow"`    // Directories, files or globs that may be transferred, empty = disabled
+	Deny    []string `yaml:"deny"`     // Excluded even if allowed, in addition to the built-in sensitive files
+	MaxSize int      `yaml:"max_size"` // MB per file, 0 = unlimited
+
+	MaxUploads int `yaml:"max_uploads"` // Concurrent file_upload sessions, outside max_sessions; 0 = uploads disabled
+}
+
+// LogsConfig controls which files read_log may read
+type LogsConfig struct {
+	Allow   []string `yaml:"allow"`   // Directories, files or globs
+	Deny    []string `yaml:"deny"`    // Excluded even if allowed, in addition to the built-in sensitive files
+	Journal bool     `yaml:"journal"` // Allow reading the systemd journal
 
-	return &config, nil
+	MaxFollows int `yaml:"max_follows"` // Concurrent read_log follow sessions, outside max_sessions; 0 = follow disabled
+}
+
+// LoggingConfig contains logging settings
+type LoggingConfig struct {
+	Level      string `yaml:"level"`       // debug, info, warn, error
+	Format     string `yaml:"format"`      // text or json
+	File       string `yaml:"file"`        // empty logs to stderr
+	MaxSize    int    `yaml:"max_size"`    // megabytes before rotation
+	MaxBackups int    `yaml:"max_backups"` // rotated files to keep
+	MaxAge     int    `yaml:"max_age"`     // days to keep rotated files
+}
+
+// Load reads the configuration file and applies FLEET_MATE_* environment
+// overrides on top of the built-in defaults
+func Load(filename string) (*Config, error) {
+	return Loader{File: filename, Environ: os.Environ()}.Load()
 }
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.Mate.ID == "" {
-		return fmt.Errorf("mate.id is required")
+	if c.Mate.ID == "" && c.Mate.StateDir == "" {
+		return fmt.Errorf("mate.id or mate.state_dir is required")
 	}
-	if c.Navigator.URL == "" {
-		return fmt.Errorf("navigator.url is required")
+	if len(c.Navigator.Endpoints()) == 0 && !c.Discovery.canAdoptURL() {
+		return fmt.Errorf("navigator.url or navigator.urls is required unless discovery with discovery.secret is enabled")
+	}
+	if c.Discovery.UDP.Enabled && (c.Discovery.UDP.Port <= 0 || c.Discovery.UDP.Port > 65535) {
+		return fmt.Errorf("discovery.udp.port must be between 1 and 65535")
+	}
+	if c.Discovery.MDNS.Enabled {
+		if c.Discovery.MDNS.Interval <= 0 {
+			return fmt.Errorf("discovery.mdns.interval must be positive")
+		}
+		if !strings.HasSuffix(c.Discovery.MDNS.Service, "._tcp") {
+			return fmt.Errorf("discovery.mdns.service must be a DNS-SD service type like _fleet-navigator._tcp")
+		}
+	}
+	switch c.Navigator.Selection {
+	case "", "priority", "round-robin":
+	default:
+		return fmt.Errorf("navigator.selection must be priority or round-robin")
 	}
 	if c.Monitoring.Interval <= 0 {
 		return fmt.Errorf("monitoring.interval must be positive")
 	}
+	if c.Commands.MaxSessions < 1 {
+		return fmt.Errorf("commands.max_sessions must be at least 1")
+	}
+	if c.Commands.QueueSize < 0 || c.Commands.MaxOutputSize < 0 {
+		return fmt.Errorf("commands.queue_size and commands.max_output_size must not be negative")
+	}
+	if _, err := pathacl.New(c.Files.Allow, c.Files.Deny); err != nil {
+		return fmt.Errorf("files: %w", err)
+	}
+	if _, err := pathacl.New(c.Logs.Allow, c.Logs.Deny); err != nil {
+		return fmt.Errorf("logs: %w", err)
+	}
+	if c.Logs.MaxFollows < 0 {
+		return fmt.Errorf("logs.max_follows must not be negative")
+	}
+	if c.Commands.MaxShells < 0 || c.Files.MaxUploads < 0 {
+		return fmt.Errorf("commands.max_shells and files.max_uploads must not be negative")
+	}
+	if c.Audit.MaxSize < 0 || c.Audit.MaxFiles < 0 {
+		return fmt.Errorf("audit.max_size and audit.max_files must not be negative")
+	}
+	if c.Files.MaxSize < 0 {
+		return fmt.Errorf("files.max_size must not be negative")
+	}
+	if c.Navigator.ReconnectMultiplier != 0 && c.Navigator.ReconnectMultiplier < 1 {
+		return fmt.Errorf("navigator.reconnect_multiplier must be at least 1")
+	}
+	if c.Navigator.ReconnectJitter < 0 || c.Navigator.ReconnectJitter > 1 {
+		return fmt.Errorf("navigator.reconnect_jitter must be between 0 and 1")
+	}
+	if (c.Navigator.TLS.CertFile == "") != (c.Navigator.TLS.KeyFile == "") {
+		return fmt.Errorf("navigator.tls.cert_file and navigator.tls.key_file must be set together")
+	}
+	for _, pin := range c.Navigator.TLS.PinnedKeys {
+		if _, err := ParsePin(pin); err != nil {
+			return fmt.Errorf("navigator.tls.pinned_keys: %w", err)
+		}
+	}
+	return nil
+}
+
+// canAdoptURL reports whether a verified announcement can supply the Navigator URL
+func (d DiscoveryConfig) canAdoptURL() bool {
+	return d.Secret != "" && (d.UDP.Enabled || d.MDNS.Enabled)
+}
+
+// Endpoints returns the Navigator URLs in priority order
+func (n NavigatorConfig) Endpoints() []string {
+	if len(n.URLs) > 0 {
+		return n.URLs
+	}
+	if n.URL != "" {
+		return []string{n.URL}
+	}
 	return nil
 }
+
+// ParsePin decodes a public key pin given as hex or "sha256/<base64>"
+func ParsePin(pin string) ([]byte, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+
+	if raw, err := hex.DecodeString(strings.ReplaceAll(value, ":", "")); err == nil && len(raw) == sha256.Size {
+		return raw, nil
+	}
+	if raw, err := base64.StdEncoding.DecodeString(value); err == nil && len(raw) == sha256.Size {
+		return raw, nil
+	}
+
+	return nil, fmt.Errorf("invalid pin %q, expected a SHA-256 hash in hex or sha256/base64 form", pin)
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables overriding config keys,
+// e.g. FLEET_MATE_NAVIGATOR_URL overrides navigator.url
+const EnvPrefix = "FLEET_MATE_"
+
+// Loader builds a Config from layered sources. Later layers win:
+// built-in defaults, the YAML file, FLEET_MATE_* environment variables
+// and finally key=value overrides from the command line.
+type Loader struct {
+	File         string   // YAML config file, may be empty
+	FileOptional bool     // Start from defaults if File does not exist
+	Environ      []string // Environment in os.Environ() form
+	Overrides    []string // key=value pairs, e.g. hardware.disk.mount_points=/,/data
+}
+
+// Default returns the built-in configuration used when no file is present
+func Default() *Config {
+	hostname, _ := os.Hostname()
+
+	return &Config{
+		Mate: MateConfig{
+			Name:     hostname,
+			StateDir: "/var/lib/fleet-mate",
+		},
+		Navigator: NavigatorConfig{
+			ReconnectInterval:    10 * time.Second,
+			ReconnectMaxInterval: 5 * time.Minute,
+			ReconnectMultiplier:  2,
+			ReconnectJitter:      0.2,
+			ListenerTimeout:      5 * time.Minute,
+			Selection:            "priority",
+			FailbackInterval:     time.Minute,
+		},
+		Monitoring: MonitoringConfig{
+			Interval: 5 * time.Second,
+			Enabled: MonitoringEnabled{
+				CPU:         true,
+				Memory:      true,
+				Disk:        true,
+				Temperature: true,
+				Network:     true,
+			},
+			Buffer: BufferConfig{
+				Enabled: true,
+				MaxSize: 20,
+				MaxAge:  24 * time.Hour,
+			},
+		},
+		Hardware: HardwareConfig{
+			Memory:      MemoryConfig{IncludeSwap: true},
+			Disk:        DiskConfig{AlertThreshold: 90},
+			Temperature: TemperatureConfig{AlertThreshold: 80},
+			GPU:         GPUConfig{NvidiaOnly: true},
+		},
+		Commands: CommandsConfig{
+			MaxSessions:   4,
+			QueueSize:     16,
+			MaxOutputSize: 10,
+			MaxShells:     2,
+		},
+		Audit: AuditConfig{
+			Enabled: true,
+			MaxSize: 50,
+		},
+		Files: FilesConfig{
+			MaxSize:    100,
+			MaxUploads: 2,
+		},
+		Logs: LogsConfig{
+			Allow:      []string{"/var/log"},
+			Journal:    true,
+			MaxFollows: 2,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Discovery: DiscoveryConfig{
+			UDP: UDPDiscoveryConfig{
+				Enabled:     true,
+				BindAddress: "0.0.0.0",
+				Port:        9090,
+			},
+			MDNS: MDNSDiscoveryConfig{
+				Service:  "_fleet-navigator._tcp",
+				Domain:   "local",
+				Interval: 30 * time.Second,
+			},
+		},
+	}
+}
+
+// Load applies all layers and validates the result
+func (l Loader) Load() (*Config, error) {
+	config := Default()
+
+	if l.File != "" {
+		data, err := os.ReadFile(l.File)
+		switch {
+		case err == nil:
+			if err := yaml.Unmarshal(data, config); err != nil {
+				return nil, fmt.Errorf("failed to parse config file: %w", err)
+			}
+		case errors.Is(err, os.ErrNotExist) && l.FileOptional:
+			// Defaults plus overrides only
+		default:
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	if err := applyEnv(config, l.Environ); err != nil {
+		return nil, err
+	}
+
+	for _, override := range l.Overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid override %q, expected key=value", override)
+		}
+		if err := Set(config, strings.TrimSpace(key), value); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate configuration
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// applyEnv applies FLEET_MATE_* variables that correspond to a config key
+func applyEnv(config *Config, environ []string) error {
+	if len(environ) == 0 {
+		return nil
+	}
+
+	byEnvName := make(map[string]string)
+	for _, key := range Keys() {
+		byEnvName[EnvPrefix+strings.ToUpper(strings.ReplaceAll(key, ".", "_"))] = key
+	}
+
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		key, known := byEnvName[name]
+		if !known {
+			continue
+		}
+		if err := Set(config, key, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Keys lists all settable config keys in dotted form, e.g. "navigator.url"
+func Keys() []string {
+	var keys []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := yamlName(field)
+			if name == "" {
+				continue
+			}
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, prefix+name+".")
+				continue
+			}
+			keys = append(keys, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return keys
+}
+
+// Set assigns a string value to the config field addressed by a dotted key.
+// Lists are comma separated, durations use Go syntax (e.g. 30s).
+func Set(config *Config, key, value string) error {
+	v := reflect.ValueOf(config).Elem()
+
+	for _, part := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("unknown config key: %s", key)
+		}
+		field, ok := fieldByYAMLName(v, part)
+		if !ok {
+			return fmt.Errorf("unknown config key: %s", key)
+		}
+		v = field
+	}
+
+	if v.Kind() == reflect.Struct {
+		return fmt.Errorf("config key %s is a section, not a value", key)
+	}
+
+	if err := setValue(v, value); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return nil
+}
+
+// setValue converts a string into the kind of v
+func setValue(v reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// fieldByYAMLName finds the struct field tagged with the given yaml name
+func fieldByYAMLName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// yamlName returns the key a struct field uses in YAML
+func yamlName(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "-" || !field.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
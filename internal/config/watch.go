@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch polls the configuration file and signals on the returned channel
+// whenever its modification time or size changes. Polling keeps this
+// working on filesystems without inotify and across editor rename-saves.
+func Watch(filename string, interval time.Duration, done <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		var lastMod time.Time
+		var lastSize int64
+		if info, err := os.Stat(filename); err == nil {
+			lastMod = info.ModTime()
+			lastSize = info.Size()
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(filename)
+				if err != nil {
+					continue
+				}
+				if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+					continue
+				}
+				lastMod = info.ModTime()
+				lastSize = info.Size()
+
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed
+}
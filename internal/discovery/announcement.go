@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Version is the current announcement format
+	Version = 1
+
+	// AnnouncementType identifies Navigator announcements
+	AnnouncementType = "FLEET_NAVIGATOR_READY"
+
+	// MaxClockSkew is how far an announcement timestamp may deviate from local time
+	MaxClockSkew = 60 * time.Second
+)
+
+// Announcement is the versioned discovery packet sent by a Navigator:
+//
+//	{"version":1,"type":"FLEET_NAVIGATOR_READY","url":"ws://host:2025/api/fleet-mate/ws",
+//	 "timestamp":1700000000,"nonce":"<hex>","signature":"<hex>"}
+//
+// signature = hex(HMAC-SHA256(secret, "<version>\n<type>\n<url>\n<timestamp>\n<nonce>"))
+type Announcement struct {
+	Version   int    `json:"version"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Timestamp int64  `json:"timestamp"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+
+	// Verified is true if the signature was checked against the shared secret
+	Verified bool `json:"-"`
+	// Source describes where the announcement came from (e.g. udp:192.168.1.5)
+	Source string `json:"-"`
+}
+
+// signingInput is the canonical byte string covered by the signature
+func (a *Announcement) signingInput() []byte {
+	return []byte(fmt.Sprintf("%d\n%s\n%s\n%d\n%s", a.Version, a.Type, a.URL, a.Timestamp, a.Nonce))
+}
+
+// Sign fills in timestamp, nonce and signature (used by Navigators and tools)
+func (a *Announcement) Sign(secret string, now time.Time) error {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	a.Version = Version
+	a.Type = AnnouncementType
+	a.Timestamp = now.Unix()
+	a.Nonce = hex.EncodeToString(nonce)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(a.signingInput())
+	a.Signature = hex.EncodeToString(mac.Sum(nil))
+	return nil
+}
+
+// Verifier checks announcements against the shared secret and rejects replays
+type Verifier struct {
+	Secret        string
+	AllowUnsigned bool // Accept legacy plain-text and unsigned packets (insecure)
+
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce → expiry
+}
+
+// Parse decodes and verifies a raw discovery packet
+func (v *Verifier) Parse(data []byte, now time.Time) (Announcement, error) {
+	text := strings.TrimSpace(string(data))
+
+	// Legacy format: the bare string without URL or signature
+	if text == AnnouncementType {
+		if !v.AllowUnsigned {
+			return Announcement{}, fmt.Errorf("unsigned legacy announcement rejected")
+		}
+		return Announcement{Type: AnnouncementType}, nil
+	}
+
+	var a Announcement
+	if err := json.Unmarshal([]byte(text), &a); err != nil {
+		return a, fmt.Errorf("malformed announcement: %w", err)
+	}
+	if a.Version != Version {
+		return a, fmt.Errorf("unsupported announcement version %d", a.Version)
+	}
+	if a.Type != AnnouncementType {
+		return a, fmt.Errorf("unexpected announcement type %q", a.Type)
+	}
+	if a.URL != "" {
+		if err := ValidateURL(a.URL); err != nil {
+			return a, err
+		}
+	}
+
+	if a.Signature == "" {
+		if !v.AllowUnsigned {
+			return a, fmt.Errorf("unsigned announcement rejected")
+		}
+		return a, nil
+	}
+
+	if err := v.checkSignature(&a); err != nil {
+		return a, err
+	}
+
+	sent := time.Unix(a.Timestamp, 0)
+	if sent.Before(now.Add(-MaxClockSkew)) || sent.After(now.Add(MaxClockSkew)) {
+		return a, fmt.Errorf("announcement timestamp outside of allowed window")
+	}
+	if len(a.Nonce) < 16 {
+		return a, fmt.Errorf("announcement nonce too short")
+	}
+	if v.replayed(a.Nonce, now) {
+		return a, fmt.Errorf("replayed announcement")
+	}
+
+	a.Verified = true
+	return a, nil
+}
+
+// VerifyRecord checks a static announcement as published in DNS-SD TXT
+// records. These are served unchanged for as long as the Navigator runs, so
+// they carry neither timestamp nor nonce and the signature only binds the
+// URL. Timestamp 0 keeps such signatures from being accepted as UDP packets.
+func (v *Verifier) VerifyRecord(a Announcement) (Announcement, error) {
+	a.Version = Version
+	a.Type = AnnouncementType
+	a.Timestamp = 0
+	a.Nonce = ""
+
+	if err := ValidateURL(a.URL); err != nil {
+		return a, err
+	}
+	if a.Signature == "" {
+		if !v.AllowUnsigned {
+			return a, fmt.Errorf("unsigned announcement rejected")
+		}
+		return a, nil
+	}
+	if err := v.checkSignature(&a); err != nil {
+		return a, err
+	}
+
+	a.Verified = true
+	return a, nil
+}
+
+// SignRecord fills in the signature for a static DNS-SD announcement
+func (a *Announcement) SignRecord(secret string) {
+	a.Version = Version
+	a.Type = AnnouncementType
+	a.Timestamp = 0
+	a.Nonce = ""
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(a.signingInput())
+	a.Signature = hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkSignature compares the HMAC of a against its signature
+func (v *Verifier) checkSignature(a *Announcement) error {
+	if v.Secret == "" {
+		return fmt.Errorf("signed announcement received but no discovery secret is configured")
+	}
+
+	got, err := hex.DecodeString(a.Signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature")
+	}
+	mac := hmac.New(sha256.New, []byte(v.Secret))
+	mac.Write(a.signingInput())
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// replayed remembers nonces for twice the allowed skew
+func (v *Verifier) replayed(nonce string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+	for n, expiry := range v.seen {
+		if now.After(expiry) {
+			delete(v.seen, n)
+		}
+	}
+
+	if _, ok := v.seen[nonce]; ok {
+		return true
+	}
+	v.seen[nonce] = now.Add(2 * MaxClockSkew)
+	return false
+}
+
+// ValidateURL accepts only ws:// and wss:// URLs with a host
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid navigator URL: %w", err)
+	}
+	if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return fmt.Errorf("invalid navigator URL %q, expected ws:// or wss://", raw)
+	}
+	return nil
+}
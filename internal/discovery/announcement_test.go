@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+// signed returns a packet signed at now, after applying change
+func signed(t *testing.T, now time.Time, change func(a *Announcement)) []byte {
+	t.Helper()
+	a := Announcement{URL: "wss://navigator.local:2025/api/fleet-mate/ws"}
+	if err := a.Sign(testSecret, now); err != nil {
+		t.Fatal(err)
+	}
+	if change != nil {
+		change(&a)
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifierParse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name          string
+		secret        string
+		allowUnsigned bool
+		packet        []byte
+		verified      bool
+		wantErr       bool
+	}{
+		{name: "signed", secret: testSecret, packet: signed(t, now, nil), verified: true},
+		{name: "wrong secret", secret: "other", packet: signed(t, now, nil), wantErr: true},
+		{name: "no secret configured", packet: signed(t, now, nil), wantErr: true},
+		{name: "url changed", secret: testSecret, packet: signed(t, now, func(a *Announcement) {
+			a.URL = "wss://evil.local/ws"
+		}), wantErr: true},
+		{name: "timestamp changed", secret: testSecret, packet: signed(t, now, func(a *Announcement) {
+			a.Timestamp++
+		}), wantErr: true},
+		{name: "too old", secret: testSecret, packet: signed(t, now.Add(-MaxClockSkew-time.Second), nil), wantErr: true},
+		{name: "too new", secret: testSecret, packet: signed(t, now.Add(MaxClockSkew+time.Second), nil), wantErr: true},
+		{name: "within skew", secret: testSecret, packet: signed(t, now.Add(-MaxClockSkew/2), nil), verified: true},
+		{name: "malformed signature", secret: testSecret, packet: signed(t, now, func(a *Announcement) {
+			a.Signature = "zz"
+		}), wantErr: true},
+		{name: "http url", secret: testSecret, packet: signed(t, now, func(a *Announcement) {
+			a.URL = "http://navigator.local/ws"
+		}), wantErr: true},
+		{name: "wrong version", secret: testSecret, packet: []byte(`{"version":2,"type":"FLEET_NAVIGATOR_READY"}`), wantErr: true},
+		{name: "wrong type", secret: testSecret, packet: []byte(`{"version":1,"type":"HELLO"}`), wantErr: true},
+		{name: "malformed json", secret: testSecret, packet: []byte(`{"version":`), wantErr: true},
+		{name: "unsigned rejected", secret: testSecret, packet: []byte(`{"version":1,"type":"FLEET_NAVIGATOR_READY","url":"ws://a:1/ws"}`), wantErr: true},
+		{name: "unsigned allowed", allowUnsigned: true, packet: []byte(`{"version":1,"type":"FLEET_NAVIGATOR_READY","url":"ws://a:1/ws"}`)},
+		{name: "legacy rejected", secret: testSecret, packet: []byte("FLEET_NAVIGATOR_READY\n"), wantErr: true},
+		{name: "legacy allowed", allowUnsigned: true, packet: []byte("FLEET_NAVIGATOR_READY\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Verifier{Secret: tt.secret, AllowUnsigned: tt.allowUnsigned}
+			a, err := v.Parse(tt.packet, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Parse accepted the packet")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Verified != tt.verified {
+				t.Errorf("Verified = %v, want %v", a.Verified, tt.verified)
+			}
+		})
+	}
+}
+
+func TestVerifierReplay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := &Verifier{Secret: testSecret}
+	packet := signed(t, now, nil)
+
+	if _, err := v.Parse(packet, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Parse(packet, now.Add(time.Second)); err == nil {
+		t.Error("replayed packet accepted")
+	}
+	if _, err := v.Parse(signed(t, now, nil), now.Add(time.Second)); err != nil {
+		t.Errorf("fresh nonce rejected: %v", err)
+	}
+
+	// Expired nonces are forgotten, the timestamp check rejects the packet
+	// instead
+	later := now.Add(3 * MaxClockSkew)
+	if _, err := v.Parse(signed(t, later, nil), later); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.seen) != 1 {
+		t.Errorf("%d nonces remembered, want 1", len(v.seen))
+	}
+	if _, err := v.Parse(packet, later); err == nil {
+		t.Error("expired packet accepted")
+	}
+}
+
+func TestVerifyRecord(t *testing.T) {
+	record := Announcement{URL: "wss://navigator.local:2025/api/fleet-mate/ws"}
+	record.SignRecord(testSecret)
+
+	v := &Verifier{Secret: testSecret}
+	if a, err := v.VerifyRecord(record); err != nil || !a.Verified {
+		t.Fatalf("VerifyRecord = %v, %v", a.Verified, err)
+	}
+
+	changed := record
+	changed.URL = "wss://evil.local/ws"
+	if _, err := v.VerifyRecord(changed); err == nil {
+		t.Error("record with changed URL accepted")
+	}
+
+	// A record signature must not pass as a UDP packet
+	data, _ := json.Marshal(record)
+	if _, err := v.Parse(data, time.Unix(0, 0)); err == nil {
+		t.Error("record accepted as packet")
+	}
+}
@@ -0,0 +1,249 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Minimal DNS message codec, just enough for DNS-SD browsing over mDNS
+// (RFC 1035, RFC 6762, RFC 6763)
+
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+
+	dnsClassIN = 1
+
+	// mDNS uses the top bit of the class for cache-flush (responses)
+	// and unicast-response (questions)
+	mdnsClassMask = 0x7fff
+
+	// dnsMaxName is the longest name in wire format (RFC 1035 2.3.4)
+	dnsMaxName = 255
+
+	// dnsMinRecord is the smallest resource record: root name and the
+	// fixed fields
+	dnsMinRecord = 11
+)
+
+// dnsQuestion is a single query entry
+type dnsQuestion struct {
+	Name string
+	Type uint16
+}
+
+// dnsRecord is a decoded resource record. Only the fields for its type are set.
+type dnsRecord struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	Target   string   // PTR, SRV
+	Port     uint16   // SRV
+	Priority uint16   // SRV
+	Text     []string // TXT
+	IP       net.IP   // A, AAAA
+}
+
+// buildQuery encodes a standard query for the given questions
+func buildQuery(id uint16, questions []dnsQuestion) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+
+	for _, q := range questions {
+		var err error
+		if msg, err = appendName(msg, q.Name); err != nil {
+			return nil, err
+		}
+		msg = binary.BigEndian.AppendUint16(msg, q.Type)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	}
+	return msg, nil
+}
+
+// appendName encodes a domain name as uncompressed labels
+func appendName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
+
+// parseMessage decodes all answer, authority and additional records of a
+// response. Records of unknown types are returned without type specific data.
+func parseMessage(msg []byte) ([]dnsRecord, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("DNS message too short")
+	}
+	if msg[2]&0x80 == 0 {
+		return nil, fmt.Errorf("not a DNS response")
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+		if off > len(msg) {
+			return nil, fmt.Errorf("truncated DNS question")
+		}
+	}
+
+	// The counts come from the packet, only trust what fits into it
+	records := make([]dnsRecord, 0, min(rrcount, max(len(msg)-off, 0)/dnsMinRecord))
+	for i := 0; i < rrcount; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, fmt.Errorf("truncated resource record")
+		}
+
+		rr := dnsRecord{
+			Name: name,
+			Type: binary.BigEndian.Uint16(msg[off:]),
+			TTL:  binary.BigEndian.Uint32(msg[off+4:]),
+		}
+		class := binary.BigEndian.Uint16(msg[off+2:]) & mdnsClassMask
+		length := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return nil, fmt.Errorf("truncated resource data")
+		}
+		if class != dnsClassIN {
+			off += length
+			continue
+		}
+
+		if err := rr.decodeData(msg, off, length); err != nil {
+			return nil, err
+		}
+		records = append(records, rr)
+		off += length
+	}
+	return records, nil
+}
+
+// decodeData fills in the type specific fields from the record data
+func (rr *dnsRecord) decodeData(msg []byte, off, length int) error {
+	data := msg[off : off+length]
+
+	switch rr.Type {
+	case dnsTypePTR:
+		target, _, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		rr.Target = target
+
+	case dnsTypeSRV:
+		if length < 7 {
+			return fmt.Errorf("malformed SRV record")
+		}
+		rr.Priority = binary.BigEndian.Uint16(data[0:])
+		rr.Port = binary.BigEndian.Uint16(data[4:])
+		target, _, err := readName(msg, off+6)
+		if err != nil {
+			return err
+		}
+		rr.Target = target
+
+	case dnsTypeTXT:
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return fmt.Errorf("malformed TXT record")
+			}
+			rr.Text = append(rr.Text, string(data[i+1:i+1+n]))
+			i += 1 + n
+		}
+
+	case dnsTypeA:
+		if length != net.IPv4len {
+			return fmt.Errorf("malformed A record")
+		}
+		rr.IP = net.IP(append([]byte(nil), data...))
+
+	case dnsTypeAAAA:
+		if length != net.IPv6len {
+			return fmt.Errorf("malformed AAAA record")
+		}
+		rr.IP = net.IP(append([]byte(nil), data...))
+	}
+	return nil
+}
+
+// readName decodes a possibly compressed domain name at off and returns
+// it with a trailing dot, plus the offset right after the name. Pointers
+// must point backwards, to a name that came before.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	size := 1 // Wire length, the root label included
+
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("truncated DNS name")
+		}
+		n := int(msg[off])
+
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, fmt.Errorf("truncated DNS name pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, fmt.Errorf("DNS name compression loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			target := int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			if target >= off {
+				return "", 0, fmt.Errorf("DNS name pointer points forward")
+			}
+			off = target
+
+		case n&0xc0 != 0:
+			return "", 0, fmt.Errorf("unsupported DNS label type")
+
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, fmt.Errorf("truncated DNS label")
+			}
+			if size += 1 + n; size > dnsMaxName {
+				return "", 0, fmt.Errorf("DNS name too long")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// response returns a response header claiming the given record counts,
+// followed by body
+func response(qd, an, ns, ar uint16, body ...[]byte) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // QR, AA
+	binary.BigEndian.PutUint16(msg[4:], qd)
+	binary.BigEndian.PutUint16(msg[6:], an)
+	binary.BigEndian.PutUint16(msg[8:], ns)
+	binary.BigEndian.PutUint16(msg[10:], ar)
+	for _, b := range body {
+		msg = append(msg, b...)
+	}
+	return msg
+}
+
+// resource encodes a record with an already encoded name
+func resource(name []byte, typ, class uint16, ttl uint32, data []byte) []byte {
+	rr := append([]byte(nil), name...)
+	rr = binary.BigEndian.AppendUint16(rr, typ)
+	rr = binary.BigEndian.AppendUint16(rr, class)
+	rr = binary.BigEndian.AppendUint32(rr, ttl)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(data)))
+	return append(rr, data...)
+}
+
+func wireName(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := appendName(nil, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// label returns a single label of n letters
+func label(n int) string {
+	return string(rune(n)) + strings.Repeat("x", n)
+}
+
+func TestReadName(t *testing.T) {
+	// Three full labels, 193 bytes with the root label
+	long := strings.Repeat(label(63), 3) + "\x00"
+
+	tests := []struct {
+		name     string
+		msg      string
+		off      int
+		want     string // Empty = error
+		wantNext int
+	}{
+		{"plain", "\x03abc\x05local\x00", 0, "abc.local.", 11},
+		{"root", "\x00", 0, ".", 1},
+		{"backward pointer", "\x05local\x00\x03abc\xc0\x00", 7, "abc.local.", 13},
+		{"pointer chain", "\x05local\x00\x03abc\xc0\x00\xc0\x07", 13, "abc.local.", 15},
+		{"longest name", strings.Repeat(label(63), 3) + label(61) + "\x00", 0, strings.Repeat(strings.Repeat("x", 63)+".", 3) + strings.Repeat("x", 61) + ".", 255},
+		{"self pointer", "\xc0\x00", 0, "", 0},
+		{"pointer loop", "\x03abc\xc0\x06\xc0\x00", 0, "", 0},
+		{"forward pointer", "\xc0\x02\x03abc\x00", 0, "", 0},
+		{"pointer beyond end", "\x00\xc0\x30", 1, "", 0},
+		{"truncated pointer", "\x03abc\xc0", 0, "", 0},
+		{"truncated label", "\x05ab", 0, "", 0},
+		{"no root label", "\x03abc", 0, "", 0},
+		{"empty", "", 0, "", 0},
+		{"extended label type", "\x40abc\x00", 0, "", 0},
+		{"oversized name", strings.Repeat(label(63), 4) + "\x00", 0, "", 0},
+		{"oversized through pointer", long + label(63) + "\xc0\x00", len(long), "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, err := readName([]byte(tt.msg), tt.off)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("readName = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || next != tt.wantNext {
+				t.Errorf("readName = %q, %d, want %q, %d", got, next, tt.want, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	name := wireName(t, "navi.local")
+	a := resource(name, dnsTypeA, dnsClassIN, 120, []byte{10, 0, 0, 5})
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"short header", response(0, 0, 0, 0)[:11]},
+		{"query", make([]byte, 12)},
+		{"truncated question", response(1, 0, 0, 0, name)},
+		{"truncated record", response(0, 1, 0, 0, a[:len(name)+5])},
+		{"truncated data", response(0, 1, 0, 0, a[:len(a)-1])},
+		{"missing record", response(0, 2, 0, 0, a)},
+		{"counts beyond packet", response(0xffff, 0xffff, 0xffff, 0xffff)},
+		{"malformed A", response(0, 1, 0, 0, resource(name, dnsTypeA, dnsClassIN, 120, []byte{10, 0, 0}))},
+		{"malformed AAAA", response(0, 1, 0, 0, resource(name, dnsTypeAAAA, dnsClassIN, 120, []byte{10, 0, 0, 5}))},
+		{"malformed SRV", response(0, 1, 0, 0, resource(name, dnsTypeSRV, dnsClassIN, 120, []byte{0, 0, 0, 0, 0x07, 0xe9}))},
+		{"malformed TXT", response(0, 1, 0, 0, resource(name, dnsTypeTXT, dnsClassIN, 120, []byte("\x05ab")))},
+		{"bad PTR target", response(0, 1, 0, 0, resource(name, dnsTypePTR, dnsClassIN, 120, []byte("\xc0\x40")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if records, err := parseMessage(tt.msg); err == nil {
+				t.Fatalf("parseMessage = %d records, want error", len(records))
+			}
+		})
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	service := wireName(t, "_fleet-navigator._tcp.local")
+	instance := append([]byte("\x03Nav"), 0xc0, 12) // Nav + pointer to the question
+	srv := append([]byte{0, 1, 0, 0, 0x07, 0xe9}, wireName(t, "navi.local")...)
+	msg := response(1, 2, 0, 4,
+		service, []byte{0, dnsTypePTR, 0, dnsClassIN},
+		resource([]byte{0xc0, 12}, dnsTypePTR, dnsClassIN, 4500, instance),
+		resource(instance, dnsTypeSRV, 0x8000|dnsClassIN, 120, srv),
+	)
+	target := []byte{0xc0, byte(bytes.Index(msg, []byte("\x04navi")))} // SRV target
+	msg = append(msg, slices.Concat(
+		resource(instance, dnsTypeTXT, dnsClassIN, 4500, []byte("\x07path=/x\x00\x08scheme=w")),
+		resource(target, dnsTypeA, dnsClassIN, 120, []byte{10, 0, 0, 5}),
+		resource(target, dnsTypeAAAA, dnsClassIN, 120, net.ParseIP("fe80::1")),
+		resource(target, dnsTypeA, 3, 120, []byte{10, 0, 0, 6}), // Chaos class is skipped
+	)...)
+	records, err := parseMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const name = "Nav._fleet-navigator._tcp.local."
+	want := []dnsRecord{
+		{Name: "_fleet-navigator._tcp.local.", Type: dnsTypePTR, TTL: 4500, Target: name},
+		{Name: name, Type: dnsTypeSRV, TTL: 120, Priority: 1, Port: 2025, Target: "navi.local."},
+		{Name: name, Type: dnsTypeTXT, TTL: 4500, Text: []string{"path=/x", "", "scheme=w"}},
+		{Name: "navi.local.", Type: dnsTypeA, TTL: 120, IP: net.IPv4(10, 0, 0, 5)},
+		{Name: "navi.local.", Type: dnsTypeAAAA, TTL: 120, IP: net.ParseIP("fe80::1")},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("parseMessage = %d records, want %d", len(records), len(want))
+	}
+	for i, rr := range records {
+		w := want[i]
+		if rr.Name != w.Name || rr.Type != w.Type || rr.TTL != w.TTL || rr.Target != w.Target ||
+			rr.Port != w.Port || rr.Priority != w.Priority || !slices.Equal(rr.Text, w.Text) || !rr.IP.Equal(w.IP) {
+			t.Errorf("record %d = %+v, want %+v", i, rr, w)
+		}
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	msg, err := buildQuery(7, []dnsQuestion{{Name: "_fleet-navigator._tcp.local.", Type: dnsTypePTR}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, next, err := readName(msg, 12)
+	if err != nil || name != "_fleet-navigator._tcp.local." || next+4 != len(msg) {
+		t.Fatalf("question = %q, %d, %v", name, next, err)
+	}
+	if _, err := buildQuery(7, []dnsQuestion{{Name: "a..local"}}); err == nil {
+		t.Error("empty label accepted")
+	}
+	if _, err := buildQuery(7, []dnsQuestion{{Name: strings.Repeat("x", 64) + ".local"}}); err == nil {
+		t.Error("oversized label accepted")
+	}
+}
@@ -0,0 +1,291 @@
+package discovery
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// mdnsAddress is the IPv4 mDNS multicast group
+	mdnsAddress = "224.0.0.251:5353"
+
+	// mdnsResponseWait is how long a query collects responses
+	mdnsResponseWait = 2 * time.Second
+
+	// mdnsMaxMissed is how many browse rounds an instance may be missing
+	// before it is forgotten (and announced again once it returns)
+	mdnsMaxMissed = 3
+
+	// DefaultNavigatorPath is used when a service has no path TXT key
+	DefaultNavigatorPath = "/api/fleet-mate/ws"
+)
+
+// BrowseMDNS looks for DNS-SD instances of service (e.g.
+// "_fleet-navigator._tcp.local") every interval until done is closed.
+// New instances and instances whose URL changed are passed to handle.
+//
+// Queries are sent from an ephemeral port (RFC 6762 "legacy unicast"), so
+// responders answer directly and port 5353 stays free for avahi. The URL is
+// taken from the TXT key "url" or built from SRV target and port plus the TXT
+// keys "scheme" (ws/wss) and "path". A TXT key "sig" signs the "url" key,
+// see Verifier.VerifyRecord.
+func BrowseMDNS(service string, interval time.Duration, verifier *Verifier, done <-chan struct{}, logger *slog.Logger, handle func(Announcement)) error {
+	service = strings.TrimSuffix(service, ".") + "."
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return fmt.Errorf("failed to open mDNS socket: %w", err)
+	}
+	defer conn.Close()
+
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("mDNS Discovery started", "service", service, "interval", interval)
+
+	type seenInstance struct {
+		url    string
+		missed int
+	}
+	known := make(map[string]*seenInstance)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		instances, err := browseOnce(conn, group, service, done)
+		if err != nil {
+			logger.Warn("mDNS browse failed", "error", err)
+		}
+
+		for _, entry := range known {
+			entry.missed++
+		}
+		for _, inst := range instances {
+			a, err := inst.announcement(verifier)
+			if err != nil {
+				logger.Debug("Ignoring mDNS service", "instance", inst.name, "reason", err)
+				continue
+			}
+			if entry, ok := known[inst.name]; ok && entry.url == a.URL {
+				entry.missed = 0
+				continue
+			}
+			known[inst.name] = &seenInstance{url: a.URL}
+			a.Source = "mdns:" + strings.TrimSuffix(inst.name, ".")
+			handle(a)
+		}
+		for name, entry := range known {
+			if entry.missed >= mdnsMaxMissed {
+				logger.Debug("mDNS service disappeared", "instance", name)
+				delete(known, name)
+			}
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// mdnsInstance is a resolved DNS-SD service instance
+type mdnsInstance struct {
+	name     string
+	target   string
+	port     uint16
+	priority uint16
+	txt      map[string]string
+	addrs    []net.IP
+}
+
+// announcement builds and verifies the announcement for an instance
+func (inst *mdnsInstance) announcement(verifier *Verifier) (Announcement, error) {
+	a := Announcement{
+		URL:       inst.txt["url"],
+		Signature: inst.txt["sig"],
+	}
+	if a.URL == "" {
+		if a.Signature != "" {
+			return a, fmt.Errorf("signed service without url TXT key")
+		}
+		u, err := inst.url()
+		if err != nil {
+			return a, err
+		}
+		a.URL = u
+	}
+	return verifier.VerifyRecord(a)
+}
+
+// url builds the WebSocket URL from SRV and TXT data
+func (inst *mdnsInstance) url() (string, error) {
+	if inst.port == 0 {
+		return "", fmt.Errorf("no SRV record")
+	}
+
+	// Prefer IPv4, avahi often publishes link-local IPv6 addresses as well
+	host := strings.TrimSuffix(inst.target, ".")
+	if len(inst.addrs) > 0 {
+		host = inst.addrs[0].String()
+	}
+	for _, ip := range inst.addrs {
+		if ip.To4() != nil {
+			host = ip.String()
+			break
+		}
+	}
+	if host == "" {
+		return "", fmt.Errorf("no SRV target")
+	}
+
+	scheme := inst.txt["scheme"]
+	if scheme == "" {
+		scheme = "ws"
+	}
+	path := inst.txt["path"]
+	if path == "" {
+		path = DefaultNavigatorPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(inst.port))) + path, nil
+}
+
+// browseOnce sends a PTR query for service and resolves the instances found.
+// Responders usually include SRV, TXT and address records as additional
+// records; anything missing is asked for in a second query.
+func browseOnce(conn *net.UDPConn, group *net.UDPAddr, service string, done <-chan struct{}) ([]*mdnsInstance, error) {
+	var records []dnsRecord
+
+	if err := sendQuery(conn, group, []dnsQuestion{{Name: service, Type: dnsTypePTR}}); err != nil {
+		return nil, err
+	}
+	records = append(records, collectResponses(conn, done)...)
+
+	instances := resolveInstances(records, service)
+
+	var missing []dnsQuestion
+	for _, inst := range instances {
+		if inst.port == 0 {
+			missing = append(missing,
+				dnsQuestion{Name: inst.name, Type: dnsTypeSRV},
+				dnsQuestion{Name: inst.name, Type: dnsTypeTXT})
+		} else if len(inst.addrs) == 0 {
+			missing = append(missing, dnsQuestion{Name: inst.target, Type: dnsTypeA})
+		}
+	}
+	if len(missing) > 0 {
+		if err := sendQuery(conn, group, missing); err != nil {
+			return instances, err
+		}
+		records = append(records, collectResponses(conn, done)...)
+		instances = resolveInstances(records, service)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].priority != instances[j].priority {
+			return instances[i].priority < instances[j].priority
+		}
+		return instances[i].name < instances[j].name
+	})
+	return instances, nil
+}
+
+// sendQuery writes a query to the mDNS group
+func sendQuery(conn *net.UDPConn, group *net.UDPAddr, questions []dnsQuestion) error {
+	msg, err := buildQuery(uint16(rand.Intn(1<<16)), questions)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteToUDP(msg, group); err != nil {
+		return fmt.Errorf("failed to send mDNS query: %w", err)
+	}
+	return nil
+}
+
+// collectResponses reads responses for mdnsResponseWait or until done is closed
+func collectResponses(conn *net.UDPConn, done <-chan struct{}) []dnsRecord {
+	var records []dnsRecord
+	deadline := time.Now().Add(mdnsResponseWait)
+	buffer := make([]byte, 9000)
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-done:
+			return records
+		default:
+		}
+
+		// Short reads so a closed done channel is noticed quickly
+		wait := time.Now().Add(500 * time.Millisecond)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			continue
+		}
+		rrs, err := parseMessage(buffer[:n])
+		if err != nil {
+			continue
+		}
+		records = append(records, rrs...)
+	}
+	return records
+}
+
+// resolveInstances joins PTR, SRV, TXT and address records into instances
+func resolveInstances(records []dnsRecord, service string) []*mdnsInstance {
+	byName := make(map[string]*mdnsInstance)
+	var instances []*mdnsInstance
+
+	for _, rr := range records {
+		if rr.Type == dnsTypePTR && strings.EqualFold(rr.Name, service) && rr.TTL > 0 {
+			key := strings.ToLower(rr.Target)
+			if _, ok := byName[key]; !ok {
+				inst := &mdnsInstance{name: rr.Target, txt: map[string]string{}}
+				byName[key] = inst
+				instances = append(instances, inst)
+			}
+		}
+	}
+
+	addrs := make(map[string][]net.IP)
+	for _, rr := range records {
+		switch rr.Type {
+		case dnsTypeSRV:
+			if inst, ok := byName[strings.ToLower(rr.Name)]; ok {
+				inst.target, inst.port, inst.priority = rr.Target, rr.Port, rr.Priority
+			}
+		case dnsTypeTXT:
+			if inst, ok := byName[strings.ToLower(rr.Name)]; ok {
+				for _, kv := range rr.Text {
+					key, value, _ := strings.Cut(kv, "=")
+					inst.txt[strings.ToLower(key)] = value
+				}
+			}
+		case dnsTypeA, dnsTypeAAAA:
+			key := strings.ToLower(rr.Name)
+			addrs[key] = append(addrs[key], rr.IP)
+		}
+	}
+
+	for _, inst := range instances {
+		inst.addrs = addrs[strings.ToLower(inst.target)]
+	}
+	return instances
+}
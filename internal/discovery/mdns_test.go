@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+)
+
+const testService = "_fleet-navigator._tcp.local."
+
+func TestResolveInstances(t *testing.T) {
+	records := []dnsRecord{
+		{Name: testService, Type: dnsTypePTR, TTL: 4500, Target: "Nav._fleet-navigator._tcp.local."},
+		{Name: "_FLEET-navigator._tcp.local.", Type: dnsTypePTR, TTL: 4500, Target: "nav._fleet-navigator._tcp.local."}, // Same instance
+		{Name: testService, Type: dnsTypePTR, TTL: 0, Target: "Gone._fleet-navigator._tcp.local."},                      // Goodbye
+		{Name: "_http._tcp.local.", Type: dnsTypePTR, TTL: 4500, Target: "Web._http._tcp.local."},
+		{Name: "NAV._fleet-navigator._tcp.local.", Type: dnsTypeSRV, TTL: 120, Priority: 5, Port: 2025, Target: "navi.local."},
+		{Name: "Nav._fleet-navigator._tcp.local.", Type: dnsTypeTXT, TTL: 4500, Text: []string{"Path=/ws", "scheme=wss", "flag"}},
+		{Name: "Web._http._tcp.local.", Type: dnsTypeSRV, TTL: 120, Port: 80, Target: "web.local."},
+		{Name: "NAVI.local.", Type: dnsTypeAAAA, TTL: 120, IP: net.ParseIP("fe80::1")},
+		{Name: "navi.local.", Type: dnsTypeA, TTL: 120, IP: net.IPv4(10, 0, 0, 5)},
+	}
+
+	instances := resolveInstances(records, testService)
+	if len(instances) != 1 {
+		t.Fatalf("%d instances, want 1", len(instances))
+	}
+	inst := instances[0]
+	if inst.name != "Nav._fleet-navigator._tcp.local." || inst.target != "navi.local." || inst.port != 2025 || inst.priority != 5 {
+		t.Errorf("instance = %+v", inst)
+	}
+	if inst.txt["path"] != "/ws" || inst.txt["scheme"] != "wss" {
+		t.Errorf("txt = %v", inst.txt)
+	}
+	if _, ok := inst.txt["flag"]; !ok {
+		t.Error("boolean TXT key dropped")
+	}
+	if len(inst.addrs) != 2 {
+		t.Errorf("addrs = %v, want both families", inst.addrs)
+	}
+	if u, err := inst.url(); err != nil || u != "wss://10.0.0.5:2025/ws" {
+		t.Errorf("url = %q, %v", u, err)
+	}
+}
+
+func TestResolveInstancesIncomplete(t *testing.T) {
+	instances := resolveInstances([]dnsRecord{
+		{Name: testService, Type: dnsTypePTR, TTL: 4500, Target: "Nav._fleet-navigator._tcp.local."},
+	}, testService)
+	if len(instances) != 1 {
+		t.Fatalf("%d instances, want 1", len(instances))
+	}
+	if _, err := instances[0].url(); err == nil {
+		t.Error("url without SRV record")
+	}
+}
+
+func TestInstanceURL(t *testing.T) {
+	tests := []struct {
+		name string
+		inst mdnsInstance
+		want string // Empty = error
+	}{
+		{"defaults", mdnsInstance{target: "navi.local.", port: 2025}, "ws://navi.local:2025" + DefaultNavigatorPath},
+		{"ipv4 preferred", mdnsInstance{target: "navi.local.", port: 2025, addrs: []net.IP{net.ParseIP("fe80::1"), net.IPv4(10, 0, 0, 5)}}, "ws://10.0.0.5:2025" + DefaultNavigatorPath},
+		{"ipv6 only", mdnsInstance{target: "navi.local.", port: 2025, addrs: []net.IP{net.ParseIP("fd00::5")}}, "ws://[fd00::5]:2025" + DefaultNavigatorPath},
+		{"path without slash", mdnsInstance{target: "navi.local.", port: 80, txt: map[string]string{"path": "ws", "scheme": "wss"}}, "wss://navi.local:80/ws"},
+		{"no SRV", mdnsInstance{target: "navi.local."}, ""},
+		{"no target", mdnsInstance{target: ".", port: 2025}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.inst.url()
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("url = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstanceAnnouncement(t *testing.T) {
+	record := Announcement{URL: "wss://navigator.local:2025/api/fleet-mate/ws"}
+	record.SignRecord(testSecret)
+	v := &Verifier{Secret: testSecret}
+
+	signed := &mdnsInstance{txt: map[string]string{"url": record.URL, "sig": record.Signature}}
+	if a, err := signed.announcement(v); err != nil || !a.Verified || a.URL != record.URL {
+		t.Errorf("signed announcement = %+v, %v", a, err)
+	}
+
+	// A signature never covers SRV data, only the url key
+	noURL := &mdnsInstance{target: "navi.local.", port: 2025, txt: map[string]string{"sig": record.Signature}}
+	if _, err := noURL.announcement(v); err == nil {
+		t.Error("signature without url key accepted")
+	}
+
+	unsigned := &mdnsInstance{target: "navi.local.", port: 2025, txt: map[string]string{}}
+	if _, err := unsigned.announcement(v); err == nil {
+		t.Error("unsigned instance accepted")
+	}
+	lax := &Verifier{AllowUnsigned: true}
+	if a, err := unsigned.announcement(lax); err != nil || a.Verified || a.URL != "ws://navi.local:2025"+DefaultNavigatorPath {
+		t.Errorf("unsigned announcement = %+v, %v", a, err)
+	}
+}
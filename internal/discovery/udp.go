@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// ListenUDP receives announcements on addr until done is closed and
+// passes every accepted one to handle. Rejected packets are logged.
+func ListenUDP(addr string, verifier *Verifier, done <-chan struct{}, logger *slog.Logger, handle func(Announcement)) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("invalid discovery address %q: %w", addr, err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	logger.Info("UDP Discovery Listener started", "address", addr)
+
+	buffer := make([]byte, 2048)
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			// Timeout ist OK, einfach weiter warten
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			logger.Warn("UDP read error", "error", err)
+			continue
+		}
+
+		announcement, err := verifier.Parse(buffer[:n], time.Now())
+		if err != nil {
+			logger.Debug("Ignoring discovery packet", "from", remoteAddr.IP, "reason", err)
+			continue
+		}
+
+		announcement.Source = "udp:" + remoteAddr.IP.String()
+		handle(announcement)
+	}
+}
@@ -3,12 +3,15 @@ package hardware
 import (
 	"bytes"
 	"fmt"
+	"log/slog"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/javafleet/fleet-mate-linux/internal/config"
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
@@ -108,67 +111,95 @@ type GPUStats struct {
 
 // Monitor handles hardware monitoring
 type Monitor struct {
-	config *config.Config
+	config atomic.Pointer[config.Config]
+	logger *slog.Logger
 }
 
 // NewMonitor creates a new hardware monitor
 func NewMonitor(cfg *config.Config) *Monitor {
-	return &Monitor{
-		config: cfg,
+	m := &Monitor{
+		logger: logging.For("hardware"),
 	}
+	m.config.Store(cfg)
+	return m
+}
+
+// SetConfig atomically replaces the configuration used for collection
+func (m *Monitor) SetConfig(cfg *config.Config) {
+	m.config.Store(cfg)
+}
+
+// cfg returns the currently active configuration
+func (m *Monitor) cfg() *config.Config {
+	return m.config.Load()
 }
 
 // Collect gathers all enabled hardware statistics
 func (m *Monitor) Collect() (*Stats, error) {
+	cfg := m.cfg()
 	stats := &Stats{
 		Timestamp: time.Now(),
-		MateID:    m.config.Mate.ID,
+		MateID:    cfg.Mate.ID,
 	}
 
 	// Always collect system info
 	if sysStats, err := m.collectSystem(); err == nil {
 		stats.System = sysStats
+	} else {
+		m.logger.Debug("Collector failed", "collector", "system", "error", err)
 	}
 
 	// CPU
-	if m.config.Monitoring.Enabled.CPU {
+	if cfg.Monitoring.Enabled.CPU {
 		if cpuStats, err := m.collectCPU(); err == nil {
 			stats.CPU = cpuStats
+		} else {
+			m.logger.Debug("Collector failed", "collector", "cpu", "error", err)
 		}
 	}
 
 	// Memory
-	if m.config.Monitoring.Enabled.Memory {
+	if cfg.Monitoring.Enabled.Memory {
 		if memStats, err := m.collectMemory(); err == nil {
 			stats.Memory = memStats
+		} else {
+			m.logger.Debug("Collector failed", "collector", "memory", "error", err)
 		}
 	}
 
 	// Disk
-	if m.config.Monitoring.Enabled.Disk {
+	if cfg.Monitoring.Enabled.Disk {
 		if diskStats, err := m.collectDisk(); err == nil {
 			stats.Disk = diskStats
+		} else {
+			m.logger.Debug("Collector failed", "collector", "disk", "error", err)
 		}
 	}
 
 	// Temperature
-	if m.config.Monitoring.Enabled.Temperature {
+	if cfg.Monitoring.Enabled.Temperature {
 		if tempStats, err := m.collectTemperature(); err == nil {
 			stats.Temperature = tempStats
+		} else {
+			m.logger.Debug("Collector failed", "collector", "temperature", "error", err)
 		}
 	}
 
 	// Network
-	if m.config.Monitoring.Enabled.Network {
+	if cfg.Monitoring.Enabled.Network {
 		if netStats, err := m.collectNetwork(); err == nil {
 			stats.Network = netStats
+		} else {
+			m.logger.Debug("Collector failed", "collector", "network", "error", err)
 		}
 	}
 
 	// GPU
-	if m.config.Monitoring.Enabled.GPU {
+	if cfg.Monitoring.Enabled.GPU {
 		if gpuStats, err := m.collectGPU(); err == nil {
 			stats.GPU = gpuStats
+		} else {
+			m.logger.Debug("Collector failed", "collector", "gpu", "error", err)
 		}
 	}
 
@@ -185,7 +216,7 @@ func (m *Monitor) collectCPU() (*CPUStats, error) {
 
 	// Per-core usage if enabled
 	var perCore []float64
-	if m.config.Hardware.CPU.CollectPerCore {
+	if m.cfg().Hardware.CPU.CollectPerCore {
 		perCore, err = cpu.Percent(time.Second, true)
 		if err != nil {
 			perCore = nil
@@ -225,7 +256,7 @@ func (m *Monitor) collectMemory() (*MemoryStats, error) {
 	}
 
 	// Swap memory if enabled
-	if m.config.Hardware.Memory.IncludeSwap {
+	if m.cfg().Hardware.Memory.IncludeSwap {
 		swap, err := mem.SwapMemory()
 		if err == nil {
 			stats.SwapTotal = swap.Total
@@ -247,7 +278,7 @@ func (m *Monitor) collectDisk() ([]DiskStats, error) {
 	var stats []DiskStats
 
 	// Filter mount points if specified
-	mountPoints := m.config.Hardware.Disk.MountPoints
+	mountPoints := m.cfg().Hardware.Disk.MountPoints
 	shouldCollect := func(mountPoint string) bool {
 		if len(mountPoints) == 0 {
 			return true
@@ -296,7 +327,7 @@ func (m *Monitor) collectTemperature() (*TemperatureStats, error) {
 	}
 
 	// Filter sensors if specified
-	sensors := m.config.Hardware.Temperature.Sensors
+	sensors := m.cfg().Hardware.Temperature.Sensors
 	shouldCollect := func(name string) bool {
 		if len(sensors) == 0 {
 			return true
@@ -335,7 +366,7 @@ func (m *Monitor) collectNetwork() ([]NetworkStats, error) {
 	var stats []NetworkStats
 
 	// Filter interfaces if specified
-	interfaces := m.config.Hardware.Network.Interfaces
+	interfaces := m.cfg().Hardware.Network.Interfaces
 	shouldCollect := func(name string) bool {
 		if len(interfaces) == 0 {
 			return true
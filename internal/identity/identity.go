@@ -0,0 +1,223 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity sources reported to the Navigator
+const (
+	SourceConfig      = "config"      // mate.id set explicitly
+	SourcePersisted   = "persisted"   // loaded from the state directory
+	SourceGenerated   = "generated"   // derived on first start
+	SourceRegenerated = "regenerated" // machine-id changed, image was cloned
+	SourceEphemeral   = "ephemeral"   // derived but could not be persisted
+	SourceRecovered   = "recovered"   // state file was corrupt, derived again
+)
+
+// stateFile is the file name inside the state directory
+const stateFile = "identity.json"
+
+// errCorrupt marks a state file that exists but can't be parsed
+var errCorrupt = errors.New("corrupt identity state")
+
+// machineIDPaths are checked in order for the systemd/dbus machine id
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// ephemeral keeps IDs that could not be persisted, by state file, so a
+// reload doesn't hand out a new random one for the process lifetime
+var ephemeral struct {
+	sync.Mutex
+	ids map[string]state
+}
+
+// Identity is the resolved mate identity
+type Identity struct {
+	ID     string
+	Source string
+}
+
+// state is persisted as JSON in the state directory
+type state struct {
+	ID            string    `json:"id"`
+	MachineIDHash string    `json:"machine_id_hash"`
+	Hostname      string    `json:"hostname"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Resolve determines the mate ID. An explicitly configured ID always wins.
+// Otherwise the ID stored in stateDir is reused as long as the machine-id
+// it was created for is unchanged; a changed machine-id means the SD card
+// was cloned and a fresh ID is derived and persisted. A corrupt state file
+// is moved aside to identity.json.corrupt and replaced.
+func Resolve(stateDir, configuredID string) (Identity, error) {
+	if configuredID != "" {
+		return Identity{ID: configuredID, Source: SourceConfig}, nil
+	}
+
+	hostname, _ := os.Hostname()
+	machineID := readMachineID()
+	machineHash := hashMachineID(machineID)
+
+	path := filepath.Join(stateDir, stateFile)
+	source := SourceGenerated
+
+	if saved, err := readState(path); err == nil {
+		if saved.MachineIDHash == machineHash {
+			return Identity{ID: saved.ID, Source: SourcePersisted}, nil
+		}
+		source = SourceRegenerated
+	} else if errors.Is(err, errCorrupt) {
+		// Keep it for inspection. If the rename fails, writeState below
+		// replaces the file anyway.
+		os.Rename(path, path+".corrupt")
+		source = SourceRecovered
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return Identity{}, fmt.Errorf("failed to read identity state: %w", err)
+	}
+
+	ephemeral.Lock()
+	defer ephemeral.Unlock()
+	current, cached := ephemeral.ids[path]
+	if !cached || current.MachineIDHash != machineHash {
+		id, err := deriveID(machineID, hostname)
+		if err != nil {
+			return Identity{}, err
+		}
+		current = state{
+			ID:            id,
+			MachineIDHash: machineHash,
+			Hostname:      hostname,
+			CreatedAt:     time.Now(),
+		}
+	}
+
+	if err := writeState(path, current); err != nil {
+		// Still usable: derived from machine-id and hostname, or kept in
+		// memory if random, it doesn't change until the mate restarts
+		if ephemeral.ids == nil {
+			ephemeral.ids = map[string]state{}
+		}
+		ephemeral.ids[path] = current
+		return Identity{ID: current.ID, Source: SourceEphemeral}, fmt.Errorf("failed to persist identity: %w", err)
+	}
+	delete(ephemeral.ids, path)
+
+	return Identity{ID: current.ID, Source: source}, nil
+}
+
+// deriveID builds "<hostname>-<hash>" from machine-id and hostname.
+// Without a machine-id a random suffix is used.
+func deriveID(machineID, hostname string) (string, error) {
+	var suffix string
+	if machineID != "" {
+		sum := sha256.Sum256([]byte(machineID + "\n" + hostname))
+		suffix = hex.EncodeToString(sum[:4])
+	} else {
+		buf := make([]byte, 4)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate identity: %w", err)
+		}
+		suffix = hex.EncodeToString(buf)
+	}
+
+	name := sanitize(hostname)
+	if name == "" {
+		name = "mate"
+	}
+	return name + "-" + suffix, nil
+}
+
+// sanitize keeps characters that are safe in the Navigator URL path
+func sanitize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		case r == '.' || r == '_':
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// readMachineID returns the machine id or an empty string
+func readMachineID() string {
+	for _, path := range machineIDPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// hashMachineID avoids storing the raw machine id, which systemd
+// recommends keeping confidential
+func hashMachineID(machineID string) string {
+	if machineID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("fleet-mate:" + machineID))
+	return hex.EncodeToString(sum[:])
+}
+
+// readState loads the persisted identity
+func readState(path string) (state, error) {
+	var s state
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+	if s.ID == "" {
+		return s, fmt.Errorf("%w: %s contains no id", errCorrupt, path)
+	}
+	return s, nil
+}
+
+// writeState persists the identity atomically, a crash leaves either the
+// old or the new file
+func writeState(path string, s state) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,65 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeMachineID points the machine-id lookup at a file with id
+func fakeMachineID(t *testing.T, id string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "machine-id")
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := machineIDPaths
+	machineIDPaths = []string{path}
+	t.Cleanup(func() { machineIDPaths = saved })
+}
+
+func TestResolve(t *testing.T) {
+	fakeMachineID(t, "0123456789abcdef")
+	dir := t.TempDir()
+
+	first, err := Resolve(dir, "")
+	if err != nil || first.Source != SourceGenerated {
+		t.Fatalf("first Resolve = %+v, %v", first, err)
+	}
+	again, err := Resolve(dir, "")
+	if err != nil || again != (Identity{ID: first.ID, Source: SourcePersisted}) {
+		t.Fatalf("second Resolve = %+v, %v", again, err)
+	}
+	if id, _ := Resolve(dir, "pi-kitchen"); id != (Identity{ID: "pi-kitchen", Source: SourceConfig}) {
+		t.Errorf("configured Resolve = %+v", id)
+	}
+
+	fakeMachineID(t, "fedcba9876543210")
+	cloned, err := Resolve(dir, "")
+	if err != nil || cloned.Source != SourceRegenerated || cloned.ID == first.ID {
+		t.Errorf("Resolve after clone = %+v, %v", cloned, err)
+	}
+}
+
+func TestResolveCorrupt(t *testing.T) {
+	fakeMachineID(t, "0123456789abcdef")
+
+	for _, content := range []string{"{\"id\": \"pi-", "{}", ""} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, stateFile)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		id, err := Resolve(dir, "")
+		if err != nil || id.ID == "" || id.Source != SourceRecovered {
+			t.Fatalf("Resolve(%q) = %+v, %v", content, id, err)
+		}
+		if kept, err := os.ReadFile(path + ".corrupt"); err != nil || string(kept) != content {
+			t.Errorf("corrupt file kept as %q, %v", kept, err)
+		}
+		if again, err := Resolve(dir, ""); err != nil || again != (Identity{ID: id.ID, Source: SourcePersisted}) {
+			t.Errorf("Resolve after recovery = %+v, %v", again, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/javafleet/fleet-mate-linux/internal/config"
+)
+
+// level is shared by all handlers so it can be changed at runtime
+var level = new(slog.LevelVar)
+
+// Setup configures the process wide logger from the logging config.
+// Both slog and the standard library log package are redirected to the
+// configured output. The returned closer releases the log file.
+func Setup(cfg config.LoggingConfig) (io.Closer, error) {
+	lvl, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	level.Set(lvl)
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = nopCloser{}
+
+	if cfg.File != "" {
+		rf, err := NewRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups, cfg.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+		out = rf
+		closer = rf
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", cfg.Format)
+	}
+
+	// Also routes the standard library log package through the handler
+	slog.SetDefault(slog.New(handler))
+
+	return closer, nil
+}
+
+// ParseLevel converts a config level name into a slog level.
+// An empty string means info.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level: %s", name)
+	}
+}
+
+// SetLevel changes the active log level
+func SetLevel(lvl slog.Level) {
+	level.Set(lvl)
+}
+
+// For returns a logger tagged with the given component name
+func For(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// nopCloser is returned when logging to stderr
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	megabyte = 1024 * 1024
+
+	// backupTimeFormat is embedded in rotated file names, e.g. fleet-mate-2024-05-01T12-00-00.000.log
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// RotatingFile is an io.Writer that writes to a file and rotates it
+// once it exceeds MaxSize, keeping at most MaxBackups old files that
+// are younger than MaxAge.
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int // megabytes, 0 disables size based rotation
+	MaxBackups int // 0 keeps all backups
+	MaxAge     int // days, 0 disables age based pruning
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens (or creates) the log file
+func NewRotatingFile(filename string, maxSize, maxBackups, maxAge int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	rf.prune()
+
+	return rf, nil
+}
+
+// Write implements io.Writer
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if rf.MaxSize > 0 && rf.size+int64(len(p)) > int64(rf.MaxSize)*megabyte && rf.size > 0 {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the underlying file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// open opens the log file in append mode and records its current size
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file to a timestamped backup and starts a new one
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	rf.file = nil
+
+	if err := os.Rename(rf.Filename, rf.backupName(time.Now())); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	rf.prune()
+	return nil
+}
+
+// backupName builds the file name for a backup created at t
+func (rf *RotatingFile) backupName(t time.Time) string {
+	dir := filepath.Dir(rf.Filename)
+	ext := filepath.Ext(rf.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(rf.Filename), ext)
+	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, t.Format(backupTimeFormat), ext))
+}
+
+// logBackup is a rotated log file found on disk
+type logBackup struct {
+	path      string
+	timestamp time.Time
+}
+
+// backups lists rotated files belonging to this log, newest first
+func (rf *RotatingFile) backups() []logBackup {
+	dir := filepath.Dir(rf.Filename)
+	ext := filepath.Ext(rf.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(rf.Filename), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var result []logBackup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, logBackup{path: filepath.Join(dir, name), timestamp: t})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].timestamp.After(result[j].timestamp)
+	})
+
+	return result
+}
+
+// prune removes backups exceeding MaxBackups or older than MaxAge
+func (rf *RotatingFile) prune() {
+	cutoff := time.Time{}
+	if rf.MaxAge > 0 {
+		cutoff = time.Now().Add(-time.Duration(rf.MaxAge) * 24 * time.Hour)
+	}
+
+	for i, backup := range rf.backups() {
+		expired := !cutoff.IsZero() && backup.timestamp.Before(cutoff)
+		excess := rf.MaxBackups > 0 && i >= rf.MaxBackups
+		if expired || excess {
+			os.Remove(backup.path)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package pathacl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Sensitive files that are never readable or writable remotely, whatever
+// the configuration allows
+var Sensitive = []string{
+	"/etc/shadow",
+	"/etc/shadow-",
+	"/etc/gshadow",
+	"/etc/gshadow-",
+	"/etc/sudoers",
+	"/etc/sudoers.d",
+	"/etc/ssh/ssh_host_*_key",
+	"/etc/ssl/private",
+	"/root/.ssh",
+	"/home/*/.ssh",
+	"/root/.gnupg",
+	"/home/*/.gnupg",
+}
+
+// List decides which files may be accessed. Entries are absolute
+// directories or files or glob patterns ("/var/log/*.log", "*" doesn't
+// cross "/"); an entry also covers everything below what it matches.
+// Paths are checked after making them absolute, removing ".." and
+// following symlinks, so a link can't lead out of an allowed root.
+type List struct {
+	allow []entry
+	deny  []entry
+}
+
+// entry is one root or glob
+type entry struct {
+	pattern string
+	glob    bool
+}
+
+// New validates and compiles allow and deny entries
+func New(allow, deny []string) (*List, error) {
+	l := &List{}
+	var err error
+	if l.allow, err = compile(allow); err != nil {
+		return nil, err
+	}
+	if l.deny, err = compile(deny); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// compile checks the entries and resolves symlinks in roots, so that an
+// allowed /var/run also covers the resolved /run
+func compile(patterns []string) ([]entry, error) {
+	var out []entry
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("path %q is not absolute", p)
+		}
+		if strings.ContainsAny(p, "*?[") {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q", p)
+			}
+			out = append(out, entry{pattern: filepath.Clean(p), glob: true})
+			continue
+		}
+		root := filepath.Clean(p)
+		if real, err := filepath.EvalSymlinks(root); err == nil {
+			root = real
+		}
+		out = append(out, entry{pattern: root})
+	}
+	return out, nil
+}
+
+// Empty reports whether nothing is allowed
+func (l *List) Empty() bool {
+	return l == nil || len(l.allow) == 0
+}
+
+// Resolve returns the real path of p if access is allowed. p may not
+// exist yet (e.g. an upload target), then its directory is resolved.
+// Errors name p as given, never where it resolves to.
+func (l *List) Resolve(p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %s must be absolute", p)
+	}
+	real := resolve(filepath.Clean(p))
+
+	if l.Empty() {
+		return "", fmt.Errorf("access to %s is not allowed: no paths are configured", p)
+	}
+	for _, e := range l.deny {
+		if e.matches(real) {
+			return "", fmt.Errorf("access to %s is denied", filepath.Clean(p))
+		}
+	}
+	for _, e := range l.allow {
+		if e.matches(real) {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("access to %s is not allowed", filepath.Clean(p))
+}
+
+// Walkable reports whether a glob pattern may be expanded: the directory
+// before its first wildcard has to be inside an allowed root, or on the way
+// to matches of an allowed pattern, and must not be denied. This bounds
+// the walk, every match still has to pass Resolve.
+func (l *List) Walkable(pattern string) bool {
+	if l.Empty() || !filepath.IsAbs(pattern) {
+		return false
+	}
+	dir := resolve(fixedDir(filepath.Clean(pattern)))
+	for _, e := range l.deny {
+		if e.matches(dir) {
+			return false
+		}
+	}
+	for _, e := range l.allow {
+		if e.matches(dir) {
+			return true
+		}
+		if e.glob && e.leadsTo(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// leadsTo reports whether walking dir can reach matches of the glob: dir is
+// its fixed directory, or below that with every component matching the
+// pattern's ("/var/log/nginx-a" for "/var/log/nginx-*/access.log")
+func (e entry) leadsTo(dir string) bool {
+	base := fixedDir(e.pattern)
+	if dir == base {
+		return true
+	}
+	if base != "/" && !strings.HasPrefix(dir, base+"/") {
+		return false
+	}
+	parts := strings.Split(e.pattern, "/")
+	for i, part := range strings.Split(dir, "/") {
+		if i == len(parts) {
+			return true
+		}
+		if ok, _ := filepath.Match(parts[i], part); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// fixedDir returns the directory of a pattern before its first wildcard,
+// "/var/log" for "/var/log/nginx*/access.log"
+func fixedDir(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return filepath.Dir(pattern)
+}
+
+// resolve follows symlinks as far as the path exists
+func resolve(p string) string {
+	if real, err := filepath.EvalSymlinks(p); err == nil {
+		return real
+	}
+	dir, base := filepath.Split(p)
+	if dir == p || dir == "" {
+		return p
+	}
+	return filepath.Join(resolve(filepath.Clean(dir)), base)
+}
+
+// matches reports whether the entry covers path
+func (e entry) matches(path string) bool {
+	if !e.glob {
+		return path == e.pattern || e.pattern == "/" || strings.HasPrefix(path, e.pattern+"/")
+	}
+	for p := path; ; p = filepath.Dir(p) {
+		if ok, _ := filepath.Match(e.pattern, p); ok {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+	}
+}
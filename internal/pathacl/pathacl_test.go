@@ -0,0 +1,139 @@
+package pathacl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTree creates
+//
+//	logs/app.log, logs/secret/key, logs/out -> ../private, logs/in -> app.log
+//	private/data, nginx-a/access.log
+//
+// and returns its resolved root
+func testTree(t *testing.T) string {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"logs/secret", "private", "nginx-a"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"logs/app.log", "logs/secret/key", "private/data", "nginx-a/access.log"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../private", filepath.Join(root, "logs/out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("app.log", filepath.Join(root, "logs/in")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestResolve(t *testing.T) {
+	root := testTree(t)
+	l, err := New(
+		[]string{root + "/logs", root + "/nginx-*/access.log"},
+		[]string{root + "/logs/secret"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string // Resolved path, empty = refused
+	}{
+		{"file in root", root + "/logs/app.log", root + "/logs/app.log"},
+		{"root itself", root + "/logs", root + "/logs"},
+		{"not yet existing", root + "/logs/new.log", root + "/logs/new.log"},
+		{"link inside root", root + "/logs/in", root + "/logs/app.log"},
+		{"link out of root", root + "/logs/out/data", ""},
+		{"dot dot", root + "/logs/../private/data", ""},
+		{"denied below root", root + "/logs/secret/key", ""},
+		{"outside", root + "/private/data", ""},
+		{"glob match", root + "/nginx-a/access.log", root + "/nginx-a/access.log"},
+		{"glob sibling", root + "/nginx-a/error.log", ""},
+		{"relative", "logs/app.log", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.Resolve(tt.path)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Resolve(%s) = %s, want refused", tt.path, got)
+				}
+				// A refusal must not reveal where a link points
+				if strings.Contains(err.Error(), "private") && !strings.Contains(tt.path, "private") {
+					t.Errorf("error %q names the link target", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%s): %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%s) = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveEmpty(t *testing.T) {
+	l, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Resolve("/var/log/syslog"); err == nil {
+		t.Error("empty list allowed access")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, entries := range [][]string{{"var/log"}, {"/var/log/[a"}} {
+		if _, err := New(entries, nil); err == nil {
+			t.Errorf("New(%q) accepted an invalid entry", entries)
+		}
+	}
+}
+
+func TestWalkable(t *testing.T) {
+	root := testTree(t)
+	l, err := New(
+		[]string{root + "/logs", root + "/nginx-*/access.log"},
+		[]string{root + "/logs/secret"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{root + "/logs/*.log", true},
+		{root + "/logs/*/key", true},
+		{root + "/logs/secret/*", false},
+		{root + "/logs/out/*", false},
+		{root + "/private/*", false},
+		{root + "/*", true}, // Fixed part of the allowed pattern
+		{root + "/nginx-a/*", true},
+		{root + "/nginx-*/access.log", true},
+		{"/*", false},
+		{"logs/*", false},
+	}
+	for _, tt := range tests {
+		if got := l.Walkable(tt.pattern); got != tt.want {
+			t.Errorf("Walkable(%s) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package policy
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/javafleet/fleet-mate-linux/internal/sandbox"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed default.yml
+var defaultPolicy []byte
+
+// Policy decides which commands the Navigator may run and with which
+// arguments. It is loaded from a YAML file (see default.yml for the format).
+type Policy struct {
+	Version  int        `yaml:"version"`
+	Paths    []string   `yaml:"paths"`    // Trusted directories for binaries, searched in order
+	Deny     []DenyRule `yaml:"deny"`     // Checked first, a match always rejects
+	Commands []Rule     `yaml:"commands"` // Allowed commands, everything else is rejected
+
+	// Sandbox applies to every command whose rule has no sandbox of its own
+	Sandbox *sandbox.Config `yaml:"sandbox"`
+
+	// Shell allows interactive shell sessions, nil = disabled
+	Shell *ShellRule `yaml:"shell"`
+
+	// Source is the file the policy was loaded from, or "built-in"
+	Source string `yaml:"-"`
+
+	trusted []string // Paths with symlinks resolved
+}
+
+// Rule allows one command, optionally restricted by subcommand, flags and
+// arguments. Flag patterns are globs (e.g. "--vacuum-*"), argument patterns
+// are regular expressions.
+type Rule struct {
+	Command     string   `yaml:"command"`      // Base name as sent by the Navigator
+	Paths       []string `yaml:"paths"`        // Allowed binaries, default: any binary in the trusted paths
+	Subcommands []string `yaml:"subcommands"`  // Allowed values for the first positional argument
+	Positional  []string `yaml:"positional"`   // Pattern per positional argument (by position)
+	AllowFlags  []string `yaml:"allow_flags"`  // If set, only these flags are accepted
+	DenyFlags   []string `yaml:"deny_flags"`   // Flags that are always rejected
+	ValueFlags  []string `yaml:"value_flags"`  // Flags that consume the following argument
+	PathFlags   []string `yaml:"path_flags"`   // Value flags whose value is a path, checked against DenyArgs
+	DenyValues  []string `yaml:"deny_values"`  // No value of a value flag may match any of these
+	LongFlags   bool     `yaml:"long_flags"`   // Single-dash flags are words (ip -brief), not short flag clusters
+	Args        []string `yaml:"args"`         // Every positional argument must match one of these
+	DenyArgs    []string `yaml:"deny_args"`    // No positional argument may match any of these
+	MaxArgs     *int     `yaml:"max_args"`     // Maximum number of positional arguments
+	ResolveArgs bool     `yaml:"resolve_args"` // Positional arguments are paths, match them absolute and resolved
+
+	// Sandbox replaces the policy's default sandbox for this command,
+	// an empty sandbox ({}) runs the command without isolation
+	Sandbox *sandbox.Config `yaml:"sandbox"`
+
+	positional []*regexp.Regexp
+	args       []*regexp.Regexp
+	denyArgs   []*regexp.Regexp
+	denyValues []*regexp.Regexp
+}
+
+// DenyRule rejects a command outright, or only when one of its conditions
+// matches
+type DenyRule struct {
+	Command     string   `yaml:"command"`     // Glob on the base name (requested and resolved binary)
+	Subcommands []string `yaml:"subcommands"` // Deny only these subcommands
+	Flags       []string `yaml:"flags"`       // Deny only when one of these flags is present
+	Args        []string `yaml:"args"`        // Deny only when a positional argument matches
+	Reason      string   `yaml:"reason"`
+
+	args []*regexp.Regexp
+}
+
+// Decision is the outcome of a policy check
+type Decision struct {
+	Allowed bool     `json:"allowed"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Path    string   `json:"path,omitempty"` // Resolved binary
+	Rule    string   `json:"rule,omitempty"` // Matching rule, e.g. "commands[4] find" or "deny[0] rm"
+	Reason  string   `json:"reason"`
+
+	Sandbox *sandbox.Config `json:"sandbox,omitempty"` // Isolation for an allowed command
+}
+
+// Default returns the built-in policy
+func Default() *Policy {
+	p, err := parse(defaultPolicy)
+	if err != nil {
+		panic("invalid built-in command policy: " + err.Error())
+	}
+	p.Source = "built-in"
+	return p
+}
+
+// Load reads a policy file. An empty filename returns the built-in policy.
+func Load(filename string) (*Policy, error) {
+	if filename == "" {
+		return Default(), nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command policy: %w", err)
+	}
+
+	p, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command policy %s: %w", filename, err)
+	}
+	p.Source = filename
+	return p, nil
+}
+
+// parse decodes and compiles a policy
+func parse(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	if p.Version != 1 {
+		return nil, fmt.Errorf("unsupported policy version %d", p.Version)
+	}
+	if len(p.Paths) == 0 {
+		return nil, fmt.Errorf("paths must list at least one trusted directory")
+	}
+	for _, dir := range p.Paths {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("path %q is not absolute", dir)
+		}
+		// Directories that don't exist on this system are skipped
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			p.trusted = append(p.trusted, real)
+		}
+	}
+
+	if p.Sandbox != nil {
+		if err := p.Sandbox.Prepare(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Shell != nil {
+		if err := p.Shell.prepare(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range p.Deny {
+		d := &p.Deny[i]
+		if d.Command == "" {
+			return nil, fmt.Errorf("deny[%d]: command is required", i)
+		}
+		if err := checkGlobs([]string{d.Command}, d.Flags); err != nil {
+			return nil, fmt.Errorf("deny[%d]: %w", i, err)
+		}
+		var err error
+		if d.args, err = compile(d.Args); err != nil {
+			return nil, fmt.Errorf("deny[%d]: %w", i, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range p.Commands {
+		r := &p.Commands[i]
+		if r.Command == "" || strings.Contains(r.Command, "/") {
+			return nil, fmt.Errorf("commands[%d]: command must be a base name", i)
+		}
+		if seen[r.Command] {
+			return nil, fmt.Errorf("commands[%d]: duplicate rule for %s", i, r.Command)
+		}
+		seen[r.Command] = true
+
+		for _, bin := range r.Paths {
+			if !filepath.IsAbs(bin) {
+				return nil, fmt.Errorf("commands[%d]: path %q is not absolute", i, bin)
+			}
+		}
+		if err := checkGlobs(r.AllowFlags, r.DenyFlags, r.ValueFlags, r.PathFlags); err != nil {
+			return nil, fmt.Errorf("commands[%d]: %w", i, err)
+		}
+
+		if r.Sandbox != nil {
+			if err := r.Sandbox.Prepare(); err != nil {
+				return nil, fmt.Errorf("commands[%d]: %w", i, err)
+			}
+		}
+
+		var err error
+		if r.positional, err = compile(r.Positional); err != nil {
+			return nil, fmt.Errorf("commands[%d]: %w", i, err)
+		}
+		if r.args, err = compile(r.Args); err != nil {
+			return nil, fmt.Errorf("commands[%d]: %w", i, err)
+		}
+		if r.denyArgs, err = compile(r.DenyArgs); err != nil {
+			return nil, fmt.Errorf("commands[%d]: %w", i, err)
+		}
+		if r.denyValues, err = compile(r.DenyValues); err != nil {
+			return nil, fmt.Errorf("commands[%d]: %w", i, err)
+		}
+	}
+
+	return &p, nil
+}
+
+// compile compiles argument patterns
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// checkGlobs validates flag and command globs
+func checkGlobs(lists ...[]string) error {
+	for _, globs := range lists {
+		for _, g := range globs {
+			if _, err := path.Match(g, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", g)
+			}
+		}
+	}
+	return nil
+}
+
+// Check decides whether command may run with args in workDir. It never
+// executes anything, so it also serves the dry-run explain mode.
+func (p *Policy) Check(command string, args []string, workDir string) Decision {
+	d := Decision{Command: command, Args: args}
+	name := filepath.Base(command)
+
+	var rule *Rule
+	index := -1
+	for i := range p.Commands {
+		if p.Commands[i].Command == name {
+			rule, index = &p.Commands[i], i
+			break
+		}
+	}
+
+	var (
+		valueFlags []string
+		clusters   = true
+	)
+	if rule != nil {
+		valueFlags = append(slices.Clone(rule.ValueFlags), rule.PathFlags...)
+		clusters = !rule.LongFlags
+	}
+	flags, positional, values := splitArgs(args, valueFlags, clusters)
+
+	// Deny rules also apply to commands that can't be resolved, so the
+	// Navigator sees the more useful reason
+	bin, resolveErr := p.resolve(command, rule)
+	for i, deny := range p.Deny {
+		if reason, ok := deny.matches(name, filepath.Base(bin), flags, positional); ok {
+			d.Path = bin
+			d.Rule = fmt.Sprintf("deny[%d] %s", i, deny.Command)
+			d.Reason = reason
+			return d
+		}
+	}
+	if resolveErr != nil {
+		d.Reason = resolveErr.Error()
+		return d
+	}
+	d.Path = bin
+
+	if rule == nil {
+		d.Reason = fmt.Sprintf("command %s is not allowed by the policy", name)
+		return d
+	}
+	d.Rule = fmt.Sprintf("commands[%d] %s", index, rule.Command)
+
+	if reason := rule.check(flags, positional, values, workDir); reason != "" {
+		d.Reason = reason
+		return d
+	}
+
+	d.Allowed = true
+	d.Reason = fmt.Sprintf("allowed by rule for %s", rule.Command)
+	d.Sandbox = p.Sandbox
+	if rule.Sandbox != nil {
+		d.Sandbox = rule.Sandbox
+	}
+	return d
+}
+
+// resolve maps the requested command to the real absolute path of a binary
+// in the trusted directories (or the rule's explicit paths)
+func (p *Policy) resolve(command string, rule *Rule) (string, error) {
+	var candidate string
+	if strings.Contains(command, "/") {
+		if !filepath.IsAbs(command) {
+			return "", fmt.Errorf("command path %s must be absolute", command)
+		}
+		candidate = command
+	} else {
+		for _, dir := range p.Paths {
+			if isExecutable(filepath.Join(dir, command)) {
+				candidate = filepath.Join(dir, command)
+				break
+			}
+		}
+		if candidate == "" {
+			return "", fmt.Errorf("command %s not found in trusted paths", command)
+		}
+	}
+
+	real, err := filepath.EvalSymlinks(candidate)
+	if err != nil || !isExecutable(real) {
+		return "", fmt.Errorf("command %s is not an executable file", candidate)
+	}
+
+	// Explicit binaries in the rule replace the trusted directories
+	if rule != nil && len(rule.Paths) > 0 {
+		for _, bin := range rule.Paths {
+			if allowed, err := filepath.EvalSymlinks(bin); err == nil && allowed == real {
+				return real, nil
+			}
+		}
+		return "", fmt.Errorf("binary %s is not one of the allowed paths for %s", real, rule.Command)
+	}
+
+	// The binary itself may be a symlink into another trusted directory
+	// (e.g. /bin → /usr/bin), but must not resolve to somewhere else
+	for _, dir := range p.trusted {
+		if filepath.Dir(real) == dir {
+			return real, nil
+		}
+	}
+	return "", fmt.Errorf("binary %s is outside the trusted paths", real)
+}
+
+// isExecutable reports whether path is a regular file with an execute bit
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// flagValue is the value given to a value flag
+type flagValue struct {
+	flag  string
+	value string
+}
+
+// splitArgs separates flags from positional arguments and collects the
+// values of value flags. "--flag=value" is reported as "--flag", value
+// flags swallow the next argument and "--" ends flag parsing. With
+// clusters, a single-dash token ends at its first value flag like getopt
+// does: "-if/etc/x" is "-i -f /etc/x".
+func splitArgs(args []string, valueFlags []string, clusters bool) (flags, positional []string, values []flagValue) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		flag := name
+		takesValue := matchAny(valueFlags, name) || (strings.HasPrefix(name, "--") && abbreviates(valueFlags, name))
+		if !takesValue && clusters && !strings.HasPrefix(arg, "--") {
+			for j := 1; j < len(arg); j++ {
+				if matchAny(valueFlags, "-"+arg[j:j+1]) {
+					name, flag = arg[:j+1], "-"+arg[j:j+1]
+					value, hasValue, takesValue = arg[j+1:], j+1 < len(arg), true
+					break
+				}
+			}
+		}
+
+		flags = append(flags, name)
+		if !takesValue {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value, hasValue = args[i], true
+		}
+		if hasValue {
+			values = append(values, flagValue{flag: flag, value: value})
+		}
+	}
+	return flags, positional, values
+}
+
+// flagMatches reports whether flag matches one of the globs. A single-dash
+// token that isn't listed as such (e.g. "-la") counts as a cluster of short
+// flags; check decides whether all or any of them must match. When any
+// match suffices (deny lists), a long flag also matches the flags it
+// abbreviates, getopt_long accepts "--outp" for "--output".
+func flagMatches(globs []string, flag string, all bool) bool {
+	if matchAny(globs, flag) {
+		return true
+	}
+	if strings.HasPrefix(flag, "--") {
+		return !all && abbreviates(globs, flag)
+	}
+	if len(flag) <= 2 {
+		return false
+	}
+
+	for _, c := range flag[1:] {
+		hit := matchAny(globs, "-"+string(c))
+		if all && !hit {
+			return false
+		}
+		if !all && hit {
+			return true
+		}
+	}
+	return all
+}
+
+// abbreviates reports whether the long flag is a prefix of one of the long
+// flag globs, up to the glob's first wildcard
+func abbreviates(globs []string, flag string) bool {
+	if len(flag) <= 2 {
+		return false
+	}
+	for _, g := range globs {
+		if !strings.HasPrefix(g, "--") {
+			continue
+		}
+		if i := strings.IndexAny(g, "*?["); i >= 0 {
+			g = g[:i]
+		}
+		if strings.HasPrefix(g, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether s matches one of the globs
+func matchAny(globs []string, s string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the deny rule applies and why
+func (d *DenyRule) matches(name, binary string, flags, positional []string) (string, bool) {
+	nameHit, _ := path.Match(d.Command, name)
+	binHit, _ := path.Match(d.Command, binary)
+	if !nameHit && !binHit {
+		return "", false
+	}
+
+	reason := d.Reason
+	if reason == "" {
+		reason = "denied by policy"
+	}
+
+	if len(d.Subcommands) == 0 && len(d.Flags) == 0 && len(d.args) == 0 {
+		return fmt.Sprintf("command %s is denied: %s", name, reason), true
+	}
+	if len(positional) > 0 {
+		for _, sub := range d.Subcommands {
+			if positional[0] == sub {
+				return fmt.Sprintf("%s %s is denied: %s", name, sub, reason), true
+			}
+		}
+	}
+	for _, flag := range flags {
+		if flagMatches(d.Flags, flag, false) {
+			return fmt.Sprintf("flag %s is denied for %s: %s", flag, name, reason), true
+		}
+	}
+	for _, arg := range positional {
+		for _, re := range d.args {
+			if re.MatchString(arg) {
+				return fmt.Sprintf("argument %s is denied for %s: %s", arg, name, reason), true
+			}
+		}
+	}
+	return "", false
+}
+
+// check applies the rule's restrictions and returns the reason for a
+// rejection, or "" if the arguments are acceptable
+func (r *Rule) check(flags, positional []string, values []flagValue, workDir string) string {
+	for _, flag := range flags {
+		if r.flagMatches(r.DenyFlags, flag, false) {
+			return fmt.Sprintf("flag %s is not allowed for %s", flag, r.Command)
+		}
+		if len(r.AllowFlags) > 0 && !r.flagMatches(r.AllowFlags, flag, true) {
+			return fmt.Sprintf("flag %s is not in the allowed flags for %s", flag, r.Command)
+		}
+	}
+
+	for _, v := range values {
+		for _, re := range r.denyValues {
+			if re.MatchString(v.value) {
+				return fmt.Sprintf("value %s of %s is not allowed for %s", v.value, v.flag, r.Command)
+			}
+		}
+		// Path values are always resolved, like resolve_args does
+		if !matchAny(r.PathFlags, v.flag) && !(strings.HasPrefix(v.flag, "--") && abbreviates(r.PathFlags, v.flag)) {
+			continue
+		}
+		value := resolvePath(v.value, workDir)
+		for _, re := range r.denyArgs {
+			if re.MatchString(value) {
+				return fmt.Sprintf("argument %s of %s is not allowed for %s", value, v.flag, r.Command)
+			}
+		}
+	}
+
+	if r.MaxArgs != nil && len(positional) > *r.MaxArgs {
+		return fmt.Sprintf("%s accepts at most %d arguments, got %d", r.Command, *r.MaxArgs, len(positional))
+	}
+
+	if len(r.Subcommands) > 0 {
+		if len(positional) == 0 {
+			return fmt.Sprintf("%s requires one of the subcommands %s", r.Command, strings.Join(r.Subcommands, ", "))
+		}
+		allowed := false
+		for _, sub := range r.Subcommands {
+			if positional[0] == sub {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("subcommand %s is not allowed for %s", positional[0], r.Command)
+		}
+	}
+
+	for i, arg := range positional {
+		value := arg
+		if r.ResolveArgs {
+			value = resolvePath(arg, workDir)
+		}
+
+		if i < len(r.positional) && !r.positional[i].MatchString(value) {
+			return fmt.Sprintf("argument %d (%s) does not match %s for %s", i+1, arg, r.positional[i], r.Command)
+		}
+		for _, re := range r.denyArgs {
+			if re.MatchString(value) {
+				return fmt.Sprintf("argument %s is not allowed for %s", value, r.Command)
+			}
+		}
+		if len(r.args) > 0 && !matchRegexp(r.args, value) {
+			return fmt.Sprintf("argument %s does not match the allowed arguments for %s", value, r.Command)
+		}
+	}
+	return ""
+}
+
+// flagMatches is flagMatches for the rule's flags. With long_flags a
+// single-dash token is only matched as a whole.
+func (r *Rule) flagMatches(globs []string, flag string, all bool) bool {
+	if r.LongFlags && !strings.HasPrefix(flag, "--") {
+		return matchAny(globs, flag)
+	}
+	return flagMatches(globs, flag, all)
+}
+
+// matchRegexp reports whether s matches one of the patterns
+func matchRegexp(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath makes a path argument absolute, removes ".." and follows
+// symlinks as far as the path exists
+func resolvePath(arg, workDir string) string {
+	p := arg
+	if !filepath.IsAbs(p) {
+		if workDir == "" {
+			workDir = "/"
+		}
+		p = filepath.Join(workDir, p)
+	}
+	p = filepath.Clean(p)
+
+	if real, err := filepath.EvalSymlinks(p); err == nil {
+		return real
+	}
+	// Resolve the existing parent so a symlinked directory can't hide
+	// a file that doesn't exist yet
+	if real, err := filepath.EvalSymlinks(filepath.Dir(p)); err == nil {
+		return filepath.Join(real, filepath.Base(p))
+	}
+	return p
+}
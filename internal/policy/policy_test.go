@@ -0,0 +1,189 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testPolicy returns the built-in policy with its trusted paths replaced by
+// a directory holding a stub for every command it names
+func testPolicy(t *testing.T) *Policy {
+	t.Helper()
+	p := Default()
+	dir := t.TempDir()
+
+	var names []string
+	for _, r := range p.Commands {
+		names = append(names, r.Command)
+	}
+	for _, d := range p.Deny {
+		names = append(names, d.Command)
+	}
+	names = append(names, "bash", "vim")
+	for _, name := range names {
+		if strings.ContainsAny(name, "*?[") {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/true\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Paths = []string{dir}
+	p.trusted = []string{real}
+	return p
+}
+
+func TestCheck(t *testing.T) {
+	p := testPolicy(t)
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		allowed bool
+	}{
+		{"plain command", "df", []string{"-h"}, true},
+		{"unknown command", "vim", nil, false},
+		{"denied command", "rm", []string{"-rf", "/"}, false},
+		{"denied shell glob", "bash", []string{"-c", "id"}, false},
+		{"relative command path", "bin/df", nil, false},
+
+		// Secret paths, also through .. and value flags
+		{"cat log", "cat", []string{"/var/log/syslog"}, true},
+		{"cat shadow", "cat", []string{"/etc/shadow"}, false},
+		{"cat shadow relative", "cat", []string{"../../etc/shadow"}, false},
+		{"cat ssh key", "cat", []string{"/home/pi/.ssh/id_ed25519"}, false},
+		{"head shadow", "head", []string{"-n", "5", "/etc/shadow"}, false},
+		{"grep pattern file", "grep", []string{"-f", "/etc/shadow", "/var/log/syslog"}, false},
+		{"grep pattern file joined", "grep", []string{"--file=/etc/shadow", "/var/log/syslog"}, false},
+		{"grep pattern file in cluster", "grep", []string{"-if/etc/shadow", "/var/log/syslog"}, false},
+		{"grep expression", "grep", []string{"-i", "-e", "error", "/var/log/syslog"}, true},
+		{"grep recursive", "grep", []string{"-r", "root", "/etc"}, false},
+		{"grep recursive in cluster", "grep", []string{"-iR", "root", "/etc"}, false},
+		{"date format", "date", []string{"+%s"}, true},
+		{"date reference", "date", []string{"-r", "/etc/shadow"}, false},
+		{"date reference joined", "date", []string{"--reference=/etc/shadow"}, false},
+		{"date set", "date", []string{"-s", "2020-01-01"}, false},
+		{"wc files0-from", "wc", []string{"--files0-from=/etc/shadow"}, false},
+		{"sort random source", "sort", []string{"--random-source", "/etc/shadow", "/var/log/syslog"}, false},
+		{"file magic file", "file", []string{"-m", "/etc/shadow", "/bin/ls"}, false},
+		{"du files0-from", "du", []string{"--files0-from=/etc/shadow"}, false},
+		{"du", "du", []string{"-sh", "/var/log"}, true},
+		{"find files0-from", "find", []string{"-files0-from", "/etc/shadow"}, false},
+
+		// Abbreviated long flags
+		{"sort output", "sort", []string{"--output", "/tmp/x", "/var/log/syslog"}, false},
+		{"sort output abbreviated", "sort", []string{"--outp=/tmp/x", "/var/log/syslog"}, false},
+		{"journalctl rotate abbreviated", "journalctl", []string{"--rot"}, false},
+		{"journalctl vacuum", "journalctl", []string{"--vacuum-size=1M"}, false},
+		{"journalctl unit", "journalctl", []string{"-u", "nginx", "--since", "today"}, true},
+		{"dmesg clear abbreviated", "dmesg", []string{"--cle"}, false},
+		{"ss kill abbreviated", "ss", []string{"--kil"}, false},
+		{"ss listening", "ss", []string{"-tlnp"}, true},
+
+		// curl must not read local files
+		{"curl url", "curl", []string{"-s", "https://example.com/health"}, true},
+		{"curl post", "curl", []string{"-d", "a=1", "https://example.com"}, true},
+		{"curl file scheme", "curl", []string{"file:///etc/passwd"}, false},
+		{"curl file scheme upper", "curl", []string{"FILE:///etc/passwd"}, false},
+		{"curl url glob", "curl", []string{"{http,file}:///etc/passwd"}, false},
+		{"curl url brackets", "curl", []string{"fil[e]:///etc/passwd"}, false},
+		{"curl data file", "curl", []string{"-d", "@/etc/passwd", "https://example.com"}, false},
+		{"curl data file joined", "curl", []string{"--data-binary=@/etc/passwd", "https://example.com"}, false},
+		{"curl url flag", "curl", []string{"--url", "file:///etc/passwd"}, false},
+		{"curl config", "curl", []string{"-K", "/tmp/curlrc"}, false},
+		{"curl output", "curl", []string{"-o", "/tmp/x", "https://example.com"}, false},
+
+		// ip takes single-dash words
+		{"ip brief", "ip", []string{"-brief", "addr"}, true},
+		{"ip batch", "ip", []string{"-b", "/tmp/cmds"}, false},
+		{"ip batch word", "ip", []string{"-batch", "/tmp/cmds"}, false},
+		{"ip batch long", "ip", []string{"--batch", "/tmp/cmds"}, false},
+		{"ip add", "ip", []string{"addr", "add", "10.0.0.1/24", "dev", "eth0"}, false},
+		{"ip add abbreviated", "ip", []string{"a", "a", "10.0.0.1/24", "dev", "eth0"}, false},
+		{"ip set abbreviated", "ip", []string{"link", "s", "eth0", "down"}, false},
+		{"ip delete abbreviated", "ip", []string{"r", "d", "default"}, false},
+		{"ip netns exec", "ip", []string{"-n", "foo", "link", "set", "eth0", "down"}, false},
+		{"ip object", "ip", []string{"r"}, true},
+		{"ip show", "ip", []string{"-4", "addr", "show", "dev", "eth0"}, true},
+
+		// wget only downloads into the working directory
+		{"wget url", "wget", []string{"-q", "https://example.com/file"}, true},
+		{"wget output document", "wget", []string{"-O", "/etc/cron.d/x", "https://example.com"}, false},
+		{"wget directory prefix", "wget", []string{"--directory-prefix=/etc", "https://example.com"}, false},
+		{"wget input file", "wget", []string{"-i", "/etc/shadow"}, false},
+		{"wget post file", "wget", []string{"--post-file=/etc/shadow", "https://example.com"}, false},
+		{"wget execute", "wget", []string{"-e", "output_document=/etc/x", "https://example.com"}, false},
+
+		// Subcommands, allow_flags and positional patterns
+		{"systemctl status", "systemctl", []string{"status", "nginx"}, true},
+		{"systemctl restart", "systemctl", []string{"restart", "nginx"}, false},
+		{"systemctl remote", "systemctl", []string{"-H", "host", "status"}, false},
+		{"systemctl output", "systemctl", []string{"-o", "json", "--no-pager", "status", "nginx"}, true},
+		{"systemctl message shifts verb", "systemctl", []string{"--message", "status", "stop", "sshd"}, false},
+		{"systemctl job-mode shifts verb", "systemctl", []string{"--job-mode", "status", "stop", "sshd"}, false},
+		{"apt list", "apt", []string{"list", "--installed"}, true},
+		{"apt target release shifts verb", "apt", []string{"-t", "list", "install", "foo"}, false},
+		{"service status", "service", []string{"nginx", "status"}, true},
+		{"service stop", "service", []string{"nginx", "stop"}, false},
+		{"hostname file", "hostname", []string{"-F", "/etc/hostname"}, false},
+		{"hostname args", "hostname", []string{"newname"}, false},
+		{"env", "env", nil, true},
+		{"env command", "env", []string{"sh"}, false},
+		{"find delete", "find", []string{"/tmp", "-delete"}, false},
+		{"find name", "find", []string{"/var/log", "-name", "*.log"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Check(tt.command, tt.args, "/tmp")
+			if d.Allowed != tt.allowed {
+				t.Errorf("Check(%s %q) allowed = %v, want %v (%s)", tt.command, tt.args, d.Allowed, tt.allowed, d.Reason)
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		valueFlags []string
+		clusters   bool
+		flags      []string
+		positional []string
+		values     []string
+	}{
+		{"cluster", []string{"-la", "/tmp"}, nil, true, []string{"-la"}, []string{"/tmp"}, nil},
+		{"value flag", []string{"-n", "5", "f"}, []string{"-n"}, true, []string{"-n"}, []string{"f"}, []string{"5"}},
+		{"joined value", []string{"--lines=5", "f"}, []string{"--lines"}, true, []string{"--lines"}, []string{"f"}, []string{"5"}},
+		{"cluster ends at value flag", []string{"-if/etc/x"}, []string{"-f"}, true, []string{"-if"}, nil, []string{"/etc/x"}},
+		{"double dash", []string{"--", "-x"}, nil, true, nil, []string{"-x"}, nil},
+		{"words", []string{"-brief", "addr"}, nil, false, []string{"-brief"}, []string{"addr"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, positional, values := splitArgs(tt.args, tt.valueFlags, tt.clusters)
+			var got []string
+			for _, v := range values {
+				got = append(got, v.value)
+			}
+			if !slices.Equal(flags, tt.flags) {
+				t.Errorf("flags = %q, want %q", flags, tt.flags)
+			}
+			if !slices.Equal(positional, tt.positional) {
+				t.Errorf("positional = %q, want %q", positional, tt.positional)
+			}
+			if !slices.Equal(got, tt.values) {
+				t.Errorf("values = %q, want %q", got, tt.values)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/sandbox"
+)
+
+// DefaultShellIdleTimeout closes shells without input after this long
+const DefaultShellIdleTimeout = 15 * time.Minute
+
+// ShellRule allows interactive shell sessions. Without a shell section in
+// the policy, shell_open is always rejected. Deny rules don't apply to
+// shells, the shell section is the explicit permission.
+type ShellRule struct {
+	Shells      []string      `yaml:"shells"`       // Allowed shell binaries (absolute), the first is the default
+	Operators   []string      `yaml:"operators"`    // Navigator users that may open a shell, empty = everyone
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // Close after this long without input, default 15m
+
+	// Sandbox replaces the policy's default sandbox for shells
+	Sandbox *sandbox.Config `yaml:"sandbox"`
+}
+
+// prepare validates the shell section
+func (s *ShellRule) prepare() error {
+	if len(s.Shells) == 0 {
+		return fmt.Errorf("shell: shells must list at least one binary")
+	}
+	for _, sh := range s.Shells {
+		if !filepath.IsAbs(sh) {
+			return fmt.Errorf("shell: %q is not absolute", sh)
+		}
+	}
+	if s.IdleTimeout < 0 {
+		return fmt.Errorf("shell: idle_timeout must not be negative")
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = DefaultShellIdleTimeout
+	}
+	if s.Sandbox != nil {
+		if err := s.Sandbox.Prepare(); err != nil {
+			return fmt.Errorf("shell: %w", err)
+		}
+	}
+	return nil
+}
+
+// CheckShell decides whether operator may open shell. An empty shell
+// selects the default shell.
+func (p *Policy) CheckShell(shell, operator string) Decision {
+	d := Decision{Command: shell, Rule: "shell"}
+	if p.Shell == nil {
+		d.Rule = ""
+		d.Reason = "interactive shells are disabled by the policy"
+		return d
+	}
+	if len(p.Shell.Operators) > 0 && !slices.Contains(p.Shell.Operators, operator) {
+		d.Reason = fmt.Sprintf("operator %q may not open a shell", operator)
+		return d
+	}
+
+	if shell == "" {
+		shell = p.Shell.Shells[0]
+		d.Command = shell
+	}
+	real, err := filepath.EvalSymlinks(shell)
+	if err != nil || !isExecutable(real) {
+		d.Reason = fmt.Sprintf("shell %s is not an executable file", shell)
+		return d
+	}
+	d.Path = real
+
+	allowed := false
+	for _, sh := range p.Shell.Shells {
+		if r, err := filepath.EvalSymlinks(sh); err == nil && r == real {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		d.Reason = fmt.Sprintf("shell %s is not allowed by the policy", shell)
+		return d
+	}
+
+	d.Allowed = true
+	d.Reason = "allowed by shell rule"
+	d.Sandbox = p.Sandbox
+	if p.Shell.Sandbox != nil {
+		d.Sandbox = p.Shell.Sandbox
+	}
+	return d
+}
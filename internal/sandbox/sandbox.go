@@ -0,0 +1,251 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// helperArg marks a re-exec of the agent that applies resource limits to
+// itself and then execs the actual command. Go cannot set rlimits on a
+// child between fork and exec, limits set afterwards would race.
+const helperArg = "__fleet-mate-sandbox"
+
+// defaultPath is used when the environment is scrubbed and no PATH is given
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Config describes how a command is isolated. The zero value changes nothing.
+type Config struct {
+	User           string   `yaml:"user" json:"user,omitempty"`                       // Run as this user instead of the agent's user
+	Group          string   `yaml:"group" json:"group,omitempty"`                     // Primary group, default: the user's group
+	Rlimits        Rlimits  `yaml:"rlimits" json:"rlimits,omitempty"`                 // Resource limits
+	PrivateMount   bool     `yaml:"private_mount" json:"private_mount,omitempty"`     // New mount namespace (mounts don't propagate back)
+	PrivateNetwork bool     `yaml:"private_network" json:"private_network,omitempty"` // New network namespace, only loopback
+	ScrubEnv       bool     `yaml:"scrub_env" json:"scrub_env,omitempty"`             // Start from an empty environment
+	PassEnv        []string `yaml:"pass_env" json:"pass_env,omitempty"`               // Variables kept from the agent's environment
+	Env            []string `yaml:"env" json:"env,omitempty"`                         // Additional KEY=VALUE variables
+
+	uid, gid uint32
+	home     string
+}
+
+// Rlimits are applied with setrlimit before the command starts. Zero means
+// unlimited (inherited from the agent).
+type Rlimits struct {
+	CPU    uint64 `yaml:"cpu" json:"cpu,omitempty"`       // CPU time in seconds
+	Memory uint64 `yaml:"memory" json:"memory,omitempty"` // Address space in MB
+	NoFile uint64 `yaml:"nofile" json:"nofile,omitempty"` // Open files
+	NProc  uint64 `yaml:"nproc" json:"nproc,omitempty"`   // Processes of the user, needs a dedicated sandbox user
+}
+
+// Prepare validates the configuration and looks up user and group. It
+// rejects what the agent isn't privileged to do, instead of failing every
+// command later.
+func (c *Config) Prepare() error {
+	for _, kv := range c.Env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			return fmt.Errorf("sandbox env %q is not KEY=VALUE", kv)
+		}
+	}
+	if (c.PrivateMount || c.PrivateNetwork) && !hasCapability(unix.CAP_SYS_ADMIN) {
+		return fmt.Errorf("sandbox namespaces require CAP_SYS_ADMIN, the agent has to run as root")
+	}
+
+	if c.User == "" {
+		if c.Group != "" {
+			return fmt.Errorf("sandbox group requires a sandbox user")
+		}
+		// The limit counts all processes of the uid, here the agent's own
+		if c.Rlimits.NProc > 0 {
+			return fmt.Errorf("sandbox rlimits.nproc requires a dedicated sandbox user")
+		}
+		return nil
+	}
+	if !hasCapability(unix.CAP_SETUID) || !hasCapability(unix.CAP_SETGID) {
+		return fmt.Errorf("sandbox user %s requires CAP_SETUID and CAP_SETGID, the agent has to run as root", c.User)
+	}
+
+	u, err := user.Lookup(c.User)
+	if err != nil {
+		return fmt.Errorf("sandbox user: %w", err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("sandbox user %s: invalid uid %s", c.User, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("sandbox user %s: invalid gid %s", c.User, u.Gid)
+	}
+
+	if c.Group != "" {
+		g, err := user.LookupGroup(c.Group)
+		if err != nil {
+			return fmt.Errorf("sandbox group: %w", err)
+		}
+		if gid, err = strconv.ParseUint(g.Gid, 10, 32); err != nil {
+			return fmt.Errorf("sandbox group %s: invalid gid %s", c.Group, g.Gid)
+		}
+	}
+
+	c.uid, c.gid, c.home = uint32(uid), uint32(gid), u.HomeDir
+	return nil
+}
+
+// Apply configures cmd to run inside the sandbox. It must be called after
+// cmd.Path, cmd.Args and cmd.SysProcAttr are set. Prepare must have been
+// called on c.
+func (c *Config) Apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+
+	limits := c.Rlimits.spec()
+	if c.User != "" && limits == "" {
+		// Empty Groups drops the agent's supplementary groups
+		attr.Credential = &syscall.Credential{Uid: c.uid, Gid: c.gid, Groups: []uint32{}}
+	}
+	if c.PrivateMount {
+		// Go makes / private after unsharing, nothing leaks to the host
+		attr.Unshareflags |= syscall.CLONE_NEWNS
+	}
+	if c.PrivateNetwork {
+		attr.Unshareflags |= syscall.CLONE_NEWNET
+	}
+
+	if c.ScrubEnv || len(c.Env) > 0 {
+		cmd.Env = c.environ()
+	}
+
+	if limits != "" {
+		// The helper still runs as the agent's user, the sandbox user
+		// may not be able to execute the agent binary. It switches to
+		// the sandbox user itself after setting the limits.
+		if c.User != "" {
+			limits += fmt.Sprintf(",uid=%d,gid=%d", c.uid, c.gid)
+		}
+		args := []string{cmd.Args[0], helperArg, limits, cmd.Path}
+		cmd.Args = append(args, cmd.Args...)
+		cmd.Path = "/proc/self/exe"
+	}
+}
+
+// hasCapability reports whether cap is in the agent's effective set
+func hasCapability(cap int) bool {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false
+	}
+	return data[cap/32].Effective&(1<<(uint(cap)%32)) != 0
+}
+
+// environ builds the command environment
+func (c *Config) environ() []string {
+	var env []string
+	if c.ScrubEnv {
+		for _, key := range c.PassEnv {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+		if c.User != "" {
+			env = append(env, "USER="+c.User, "LOGNAME="+c.User, "HOME="+c.home)
+		}
+	} else {
+		env = os.Environ()
+	}
+	env = append(env, c.Env...)
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			return env
+		}
+	}
+	return append(env, "PATH="+defaultPath)
+}
+
+// spec encodes the limits for the helper, e.g. "cpu=60,as=536870912"
+func (r Rlimits) spec() string {
+	var parts []string
+	if r.CPU > 0 {
+		parts = append(parts, fmt.Sprintf("cpu=%d", r.CPU))
+	}
+	if r.Memory > 0 {
+		parts = append(parts, fmt.Sprintf("as=%d", r.Memory*1024*1024))
+	}
+	if r.NoFile > 0 {
+		parts = append(parts, fmt.Sprintf("nofile=%d", r.NoFile))
+	}
+	if r.NProc > 0 {
+		parts = append(parts, fmt.Sprintf("nproc=%d", r.NProc))
+	}
+	return strings.Join(parts, ",")
+}
+
+// rlimitResources maps the spec keys to resources
+var rlimitResources = map[string]int{
+	"cpu":    unix.RLIMIT_CPU,
+	"as":     unix.RLIMIT_AS,
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+}
+
+// IsHelper reports whether the process was started as sandbox helper
+func IsHelper() bool {
+	return len(os.Args) > 1 && os.Args[1] == helperArg
+}
+
+// RunHelper applies the resource limits, switches to the sandbox user if
+// uid and gid are given and replaces the process with the command. It
+// only returns on error, after which the process should exit.
+//
+//	argv: <name> __fleet-mate-sandbox <limits>[,uid=N,gid=N] <path> <argv0> <args...>
+func RunHelper() error {
+	if len(os.Args) < 5 {
+		return fmt.Errorf("sandbox helper: missing arguments")
+	}
+	limits, path, argv := os.Args[2], os.Args[3], os.Args[4:]
+
+	ids := map[string]int{}
+	for _, item := range strings.Split(limits, ",") {
+		key, value, _ := strings.Cut(item, "=")
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("sandbox helper: invalid limit %q", item)
+		}
+		if key == "uid" || key == "gid" {
+			ids[key] = int(n)
+			continue
+		}
+		resource, ok := rlimitResources[key]
+		if !ok {
+			return fmt.Errorf("sandbox helper: unknown limit %q", key)
+		}
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: n, Max: n}); err != nil {
+			return fmt.Errorf("sandbox helper: setrlimit %s: %w", key, err)
+		}
+	}
+
+	if uid, ok := ids["uid"]; ok {
+		// Groups first, setuid drops the privilege to change them
+		if err := syscall.Setgroups([]int{}); err != nil {
+			return fmt.Errorf("sandbox helper: setgroups: %w", err)
+		}
+		if err := syscall.Setgid(ids["gid"]); err != nil {
+			return fmt.Errorf("sandbox helper: setgid: %w", err)
+		}
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("sandbox helper: setuid: %w", err)
+		}
+	}
+
+	return syscall.Exec(path, argv, os.Environ())
+}
@@ -0,0 +1,192 @@
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item is a queued record
+type Item struct {
+	ID        string
+	Timestamp time.Time
+	Data      json.RawMessage
+}
+
+// entry is the in-memory index of one file in the queue directory
+type entry struct {
+	name      string
+	size      int64
+	timestamp time.Time
+}
+
+// Queue is a bounded FIFO persisted as one JSON file per record.
+// When MaxBytes is exceeded the oldest records are dropped; records
+// older than MaxAge are discarded.
+type Queue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	entries []entry
+	total   int64
+	seq     uint64
+}
+
+// Open loads an existing queue directory or creates a new one
+func Open(dir string, maxBytes int64, maxAge time.Duration) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	q := &Queue{dir: dir, maxBytes: maxBytes, maxAge: maxAge}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") {
+			// Leftover temp files from an interrupted write
+			if strings.HasSuffix(name, ".tmp") {
+				os.Remove(filepath.Join(dir, name))
+			}
+			continue
+		}
+		ts, ok := parseName(name)
+		if !ok {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		q.entries = append(q.entries, entry{name: name, size: info.Size(), timestamp: ts})
+		q.total += info.Size()
+	}
+
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].name < q.entries[j].name })
+
+	q.mu.Lock()
+	q.expire()
+	q.mu.Unlock()
+
+	return q, nil
+}
+
+// Push appends a record, evicting the oldest ones if the queue is full
+func (q *Queue) Push(ts time.Time, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d.json", ts.UnixNano(), q.seq%1000000)
+	path := filepath.Join(q.dir, name)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+
+	q.entries = append(q.entries, entry{name: name, size: int64(len(data)), timestamp: ts})
+	q.total += int64(len(data))
+
+	q.expire()
+	for q.maxBytes > 0 && q.total > q.maxBytes && len(q.entries) > 1 {
+		q.dropOldest()
+	}
+
+	return nil
+}
+
+// Peek returns the oldest record without removing it
+func (q *Queue) Peek() (Item, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+	if len(q.entries) == 0 {
+		return Item{}, false, nil
+	}
+
+	oldest := q.entries[0]
+	data, err := os.ReadFile(filepath.Join(q.dir, oldest.name))
+	if err != nil {
+		// Unreadable record, drop it so the queue does not get stuck
+		q.dropOldest()
+		return Item{}, false, fmt.Errorf("failed to read record %s: %w", oldest.name, err)
+	}
+
+	return Item{ID: oldest.name, Timestamp: oldest.timestamp, Data: data}, true, nil
+}
+
+// Remove deletes a record returned by Peek
+func (q *Queue) Remove(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, e := range q.entries {
+		if e.name == id {
+			os.Remove(filepath.Join(q.dir, e.name))
+			q.total -= e.size
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// Len returns the number of queued records
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// expire drops records older than maxAge. Caller holds q.mu.
+func (q *Queue) expire() {
+	if q.maxAge <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-q.maxAge)
+	for len(q.entries) > 0 && q.entries[0].timestamp.Before(cutoff) {
+		q.dropOldest()
+	}
+}
+
+// dropOldest removes the first record. Caller holds q.mu.
+func (q *Queue) dropOldest() {
+	oldest := q.entries[0]
+	os.Remove(filepath.Join(q.dir, oldest.name))
+	q.total -= oldest.size
+	q.entries = q.entries[1:]
+}
+
+// parseName extracts the timestamp from "<unixnano>-<seq>.json"
+func parseName(name string) (time.Time, bool) {
+	stamp, _, ok := strings.Cut(strings.TrimSuffix(name, ".json"), "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
+	"github.com/javafleet/fleet-mate-linux/internal/config"
+)
+
+const (
+	// defaultAuditQueryLimit caps audit_query results when no limit is given
+	defaultAuditQueryLimit = 100
+
+	// maxAuditQueryLimit is the largest page audit_query returns, older
+	// entries are fetched with the before cursor
+	maxAuditQueryLimit = 1000
+)
+
+// openAudit opens the audit journal, or returns nil if disabled
+func (c *Client) openAudit(cfg *config.Config) *audit.Journal {
+	if !cfg.Audit.Enabled {
+		return nil
+	}
+
+	file := cfg.Audit.File
+	if file == "" {
+		file = filepath.Join(cfg.Mate.StateDir, "audit.jsonl")
+	}
+
+	journal, err := audit.Open(file)
+	if err != nil {
+		c.logger.Warn("Audit journal disabled", "file", file, "error", err)
+		return nil
+	}
+	journal.MaxSize = int64(cfg.Audit.MaxSize) * 1024 * 1024
+	journal.MaxFiles = cfg.Audit.MaxFiles
+
+	// A broken chain is reported but the journal stays in use, new entries
+	// continue after the last readable one
+	n, err := journal.Verify()
+	if err != nil {
+		c.logger.Error("Audit journal verification failed", "file", file, "entries", n, "error", err)
+	} else {
+		c.logger.Info("Audit journal loaded", "file", file, "entries", n)
+	}
+	return journal
+}
+
+// auditEvent records a command handled by the client itself
+func (c *Client) auditEvent(cmdType, sessionID, command, decision, status, reason string) {
+	c.audit.Record(audit.Entry{
+		SessionID: sessionID,
+		Type:      cmdType,
+		Command:   command,
+		Decision:  decision,
+		Status:    status,
+		Reason:    reason,
+	})
+}
+
+// handleAuditQuery returns journal entries matching the payload filter
+func (c *Client) handleAuditQuery(payload map[string]interface{}) {
+	sessionID := getStringFromPayload(payload, "sessionId", "")
+	filter := audit.Filter{
+		SessionID: getStringFromPayload(payload, "session", ""),
+		Type:      getStringFromPayload(payload, "type", ""),
+		Before:    uint64(max(getIntFromPayload(payload, "before", 0), 0)),
+	}
+	limit := getIntFromPayload(payload, "limit", defaultAuditQueryLimit)
+	if limit <= 0 || limit > maxAuditQueryLimit {
+		limit = maxAuditQueryLimit
+	}
+	// One more than asked tells whether older matches are left
+	filter.Limit = limit + 1
+
+	result := map[string]interface{}{
+		"sessionId": sessionID,
+	}
+	fail := func(err error) {
+		c.logger.Warn("audit_query failed", "session", sessionID, "error", err)
+		result["error"] = err.Error()
+		c.sendData("audit_result", result)
+		c.auditEvent("audit_query", sessionID, "", audit.Allowed, "failed", err.Error())
+	}
+
+	if c.audit == nil {
+		result["error"] = "audit journal is disabled"
+		c.sendData("audit_result", result)
+		return
+	}
+
+	var err error
+	if s := getStringFromPayload(payload, "since", ""); s != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if s := getStringFromPayload(payload, "until", ""); s != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	// Scanning a large journal takes a while, don't block the command loop
+	go func() {
+		if getBoolFromPayload(payload, "verify", false) {
+			_, err := c.audit.Verify()
+			result["verified"] = err == nil
+			if err != nil {
+				result["verifyError"] = err.Error()
+			}
+		}
+
+		entries, err := c.audit.Query(filter)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+		more := len(entries) > limit
+		if more {
+			entries = entries[1:]
+			result["before"] = entries[0].Seq
+		}
+
+		result["entries"] = entries
+		result["count"] = len(entries)
+		result["more"] = more
+		result["head"] = c.audit.Head()
+		c.sendData("audit_result", result)
+
+		c.auditEvent("audit_query", sessionID, "", audit.Allowed, "completed", "")
+	}()
+}
@@ -0,0 +1,252 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
+	"github.com/javafleet/fleet-mate-linux/internal/auth"
+	"github.com/javafleet/fleet-mate-linux/internal/commands"
+)
+
+// handshakeTimeout bounds each step of the enrollment/auth exchange
+const handshakeTimeout = 15 * time.Second
+
+// credentialRetry is how often a credential that could not be stored
+// after enrollment is saved again
+const credentialRetry = time.Minute
+
+// unsavedCred is an enrolled credential whose Save failed. The join
+// token is used up, so it is kept in memory and stored as soon as possible.
+type unsavedCred struct {
+	cred *auth.Credential
+	err  error // Last save error
+}
+
+// privilegedCommands may only run on an authenticated connection
+var privilegedCommands = map[string]bool{
+	"read_log":        true,
+	"execute_command": true,
+	"cancel_session":  true,
+	"audit_query":     true,
+	"shell_open":      true,
+	"shell_input":     true,
+	"shell_resize":    true,
+	"shell_close":     true,
+	"file_download":   true,
+	"file_upload":     true,
+	"file_chunk":      true,
+	"shutdown":        true,
+}
+
+// authenticate runs enrollment (if a join token is configured and no
+// credential exists yet) followed by the challenge/response login.
+// Mates without token and credential stay unauthenticated and may only
+// run unprivileged commands.
+func (c *Client) authenticate(conn *websocket.Conn) error {
+	c.authenticated.Store(false)
+	cfg := c.cfg()
+
+	// A credential that could not be stored after enrollment is used from
+	// memory until a save succeeds
+	c.saveCredential()
+	var cred *auth.Credential
+	var err error
+	unsaved := c.unsaved.Load()
+	if unsaved != nil && unsaved.cred.MateID == cfg.Mate.ID {
+		cred = unsaved.cred
+	} else {
+		unsaved = nil
+		cred, err = auth.LoadCredential(cfg.Mate.StateDir, cfg.Mate.ID)
+	}
+	if errors.Is(err, auth.ErrNotEnrolled) {
+		if cfg.Navigator.JoinToken == "" {
+			c.logger.Warn("Mate is not enrolled and no join token is configured, privileged commands are disabled")
+			return nil
+		}
+		cred, err = c.enroll(conn, cfg.Navigator.JoinToken)
+		if u := c.unsaved.Load(); u != nil && u.cred == cred {
+			unsaved = u
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// auth_request → auth_challenge → auth_response → auth_result. A
+	// credential that could not be stored is reported, it is lost if the
+	// mate restarts before it is saved.
+	request := Message{
+		Type:      "auth_request",
+		MateID:    cfg.Mate.ID,
+		Timestamp: time.Now(),
+	}
+	if unsaved != nil {
+		request.Data = map[string]interface{}{
+			"credential_error": unsaved.err.Error(),
+		}
+	}
+	if err := c.sendMessage(request); err != nil {
+		return err
+	}
+
+	challenge, err := c.expect(conn, "auth_challenge")
+	if err != nil {
+		return err
+	}
+	nonce := getStringFromPayload(challenge.Payload, "nonce", "")
+	if len(nonce) < 16 {
+		return fmt.Errorf("auth challenge without valid nonce")
+	}
+
+	signature, err := cred.Sign(nonce)
+	if err != nil {
+		return err
+	}
+	if err := c.sendMessage(Message{
+		Type:   "auth_response",
+		MateID: cfg.Mate.ID,
+		Data: map[string]interface{}{
+			"nonce":     nonce,
+			"signature": signature,
+		},
+		Timestamp: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	result, err := c.expect(conn, "auth_result")
+	if err != nil {
+		return err
+	}
+	if !getBoolFromPayload(result.Payload, "success", false) {
+		return fmt.Errorf("authentication rejected: %s", getStringFromPayload(result.Payload, "error", "unknown reason"))
+	}
+
+	c.authenticated.Store(true)
+	c.logger.Info("Authenticated with Fleet Navigator")
+	return nil
+}
+
+// enroll exchanges the one-time join token for a long-lived credential
+func (c *Client) enroll(conn *websocket.Conn, token string) (*auth.Credential, error) {
+	cfg := c.cfg()
+	c.logger.Info("Enrolling with Fleet Navigator")
+
+	if err := c.sendMessage(Message{
+		Type:   "enroll",
+		MateID: cfg.Mate.ID,
+		Data: map[string]interface{}{
+			"join_token":      token,
+			"name":            cfg.Mate.Name,
+			"description":     cfg.Mate.Description,
+			"identity_source": cfg.Mate.IDSource,
+		},
+		Timestamp: time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+
+	result, err := c.expect(conn, "enroll_result")
+	if err != nil {
+		return nil, err
+	}
+	if !getBoolFromPayload(result.Payload, "success", false) {
+		return nil, fmt.Errorf("enrollment rejected: %s", getStringFromPayload(result.Payload, "error", "unknown reason"))
+	}
+
+	cred := &auth.Credential{
+		MateID:   cfg.Mate.ID,
+		Secret:   getStringFromPayload(result.Payload, "secret", ""),
+		IssuedAt: time.Now(),
+	}
+	if _, err := cred.Sign("probe"); err != nil {
+		return nil, fmt.Errorf("enrollment returned unusable credential: %w", err)
+	}
+	if err := cred.Save(cfg.Mate.StateDir); err != nil {
+		c.unsaved.Store(&unsavedCred{cred: cred, err: err})
+		c.logger.Error("Enrollment successful, but the credential could not be stored; retrying, it is lost if the mate restarts first",
+			"state_dir", cfg.Mate.StateDir, "error", err)
+		return cred, nil
+	}
+
+	c.logger.Info("Enrollment successful, credential stored; the join token is no longer needed",
+		"state_dir", cfg.Mate.StateDir)
+	return cred, nil
+}
+
+// saveCredential stores a credential whose Save failed after enrollment.
+// It reports whether no unsaved credential is left.
+func (c *Client) saveCredential() bool {
+	unsaved := c.unsaved.Load()
+	if unsaved == nil {
+		return true
+	}
+	stateDir := c.cfg().Mate.StateDir
+	if err := unsaved.cred.Save(stateDir); err != nil {
+		c.unsaved.CompareAndSwap(unsaved, &unsavedCred{cred: unsaved.cred, err: err})
+		c.logger.Warn("Credential still not stored", "state_dir", stateDir, "error", err)
+		return false
+	}
+	c.unsaved.CompareAndSwap(unsaved, nil)
+	c.logger.Info("Credential stored; the join token is no longer needed", "state_dir", stateDir)
+	return true
+}
+
+// retryCredentialSave saves an unsaved credential every credentialRetry
+// until it is stored or the connection ends
+func (c *Client) retryCredentialSave(connDone chan struct{}) {
+	if c.unsaved.Load() == nil {
+		return
+	}
+	ticker := time.NewTicker(credentialRetry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-connDone:
+			return
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if c.saveCredential() {
+				return
+			}
+		}
+	}
+}
+
+// expect reads the next command during the handshake and checks its type.
+// A Stop meanwhile closes conn, which ends the read with an error.
+func (c *Client) expect(conn *websocket.Conn, msgType string) (Command, error) {
+	var cmd Command
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	if err := conn.ReadJSON(&cmd); err != nil {
+		return cmd, fmt.Errorf("waiting for %s: %w", msgType, err)
+	}
+	if cmd.Type != msgType {
+		return cmd, fmt.Errorf("expected %s, got %s", msgType, cmd.Type)
+	}
+	return cmd, nil
+}
+
+// rejectUnauthenticated tells the Navigator that a privileged command was refused
+func (c *Client) rejectUnauthenticated(cmd Command) {
+	c.logger.Warn("Refusing privileged command on unauthenticated connection", "type", cmd.Type)
+	c.sendMessage(Message{
+		Type:   "command_rejected",
+		MateID: c.cfg().Mate.ID,
+		Data: map[string]interface{}{
+			"sessionId": getStringFromPayload(cmd.Payload, "sessionId", ""),
+			"command":   cmd.Type,
+			"reason":    "mate is not enrolled",
+		},
+		Timestamp: time.Now(),
+	})
+	c.auditEvent(cmd.Type, getStringFromPayload(cmd.Payload, "sessionId", ""), "", audit.Rejected, commands.StatusRejected, "mate is not enrolled")
+}
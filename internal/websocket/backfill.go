@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/config"
+	"github.com/javafleet/fleet-mate-linux/internal/hardware"
+	"github.com/javafleet/fleet-mate-linux/internal/spool"
+)
+
+// openSpool opens the offline stats queue, or returns nil if disabled
+func (c *Client) openSpool(cfg *config.Config) *spool.Queue {
+	bc := cfg.Monitoring.Buffer
+	if !bc.Enabled {
+		return nil
+	}
+
+	dir := bc.Dir
+	if dir == "" {
+		dir = filepath.Join(cfg.Mate.StateDir, "spool")
+	}
+
+	q, err := spool.Open(dir, int64(bc.MaxSize)*1024*1024, bc.MaxAge)
+	if err != nil {
+		c.logger.Warn("Offline stats buffer disabled", "dir", dir, "error", err)
+		return nil
+	}
+
+	if n := q.Len(); n > 0 {
+		c.logger.Info("Offline stats buffer loaded", "dir", dir, "samples", n)
+	}
+	return q
+}
+
+// publishStats sends a sample or queues it while the Navigator is unreachable.
+// As long as older samples are waiting, new ones are queued behind them so
+// the Navigator receives everything in order.
+func (c *Client) publishStats(stats *hardware.Stats) {
+	state, _ := c.State()
+	if c.spool != nil && (state != StateRegistered || c.spool.Len() > 0) {
+		c.bufferStats(stats)
+		return
+	}
+
+	msg := Message{
+		Type:      "stats",
+		MateID:    c.cfg().Mate.ID,
+		Data:      stats,
+		Timestamp: time.Now(),
+	}
+
+	if err := c.sendMessage(msg); err != nil {
+		if c.spool == nil {
+			c.logger.Warn("Failed to send stats", "error", err)
+			return
+		}
+		c.bufferStats(stats)
+	}
+}
+
+// bufferStats appends a sample to the offline queue and wakes the replayer
+func (c *Client) bufferStats(stats *hardware.Stats) {
+	if err := c.spool.Push(stats.Timestamp, stats); err != nil {
+		c.logger.Warn("Failed to buffer stats", "error", err)
+		return
+	}
+	c.logger.Debug("Buffered stats sample", "queued", c.spool.Len())
+	signal(c.replay)
+}
+
+// replayStats drains the offline queue oldest first while the connection
+// is up. Samples are only removed after they were written successfully.
+func (c *Client) replayStats(connDone chan struct{}) {
+	if c.spool == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-connDone:
+			return
+		case <-c.replay:
+		}
+
+		sent := 0
+		for {
+			select {
+			case <-connDone:
+				return
+			default:
+			}
+
+			item, ok, err := c.spool.Peek()
+			if err != nil {
+				c.logger.Warn("Skipping unreadable buffered sample", "error", err)
+				continue
+			}
+			if !ok {
+				break
+			}
+
+			msg := Message{
+				Type:      "stats",
+				MateID:    c.cfg().Mate.ID,
+				Data:      item.Data,
+				Backfill:  true,
+				Timestamp: item.Timestamp,
+			}
+			if err := c.sendMessage(msg); err != nil {
+				c.logger.Warn("Stats replay interrupted", "error", err, "remaining", c.spool.Len())
+				break
+			}
+			c.spool.Remove(item.ID)
+			sent++
+
+			// Small delay so live traffic is not starved
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if sent > 0 {
+			c.logger.Info("Replayed buffered stats", "samples", sent)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/config"
+)
+
+// backoff computes exponentially growing retry delays with random jitter
+type backoff struct {
+	attempt int
+}
+
+// next returns the delay before the next attempt and advances the counter
+func (b *backoff) next(cfg config.NavigatorConfig) time.Duration {
+	initial := cfg.ReconnectInterval
+	if initial <= 0 {
+		initial = time.Second
+	}
+	maxDelay := cfg.ReconnectMaxInterval
+	if maxDelay < initial {
+		maxDelay = initial
+	}
+	multiplier := cfg.ReconnectMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(initial)
+	for i := 0; i < b.attempt && delay < float64(maxDelay); i++ {
+		delay *= multiplier
+	}
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	b.attempt++
+
+	// Spread reconnects of many mates after a Navigator restart:
+	// delay ± jitter * delay
+	if cfg.ReconnectJitter > 0 {
+		delay += delay * cfg.ReconnectJitter * (2*rand.Float64() - 1)
+		if delay > float64(maxDelay) {
+			delay = float64(maxDelay)
+		}
+	}
+
+	return time.Duration(delay)
+}
+
+// reset starts over with the initial delay
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -1,30 +1,61 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net"
-	"strings"
+	"log/slog"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/javafleet/fleet-mate-linux/internal/audit"
 	"github.com/javafleet/fleet-mate-linux/internal/commands"
 	"github.com/javafleet/fleet-mate-linux/internal/config"
 	"github.com/javafleet/fleet-mate-linux/internal/hardware"
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
+	"github.com/javafleet/fleet-mate-linux/internal/spool"
 )
 
 // Client represents a WebSocket client
 type Client struct {
-	config       *config.Config
-	conn         *websocket.Conn
-	connMutex    sync.Mutex    // Protects WebSocket writes
-	monitor      *hardware.Monitor
-	commands     chan Command
-	done         chan struct{}
-	disconnected chan struct{} // Signal für Verbindungsverlust
-	wakeup       chan struct{} // Signal vom UDP Discovery Listener
+	config          atomic.Pointer[config.Config]
+	conn            *websocket.Conn
+	connMutex       sync.Mutex // Protects WebSocket writes
+	monitor         *hardware.Monitor
+	logger          *slog.Logger
+	commands        chan Command
+	done            chan struct{} // Closed by Stop, never replaced
+	stopOnce        sync.Once
+	connDone        chan struct{}                  // Closed when the current connection is torn down
+	connWG          sync.WaitGroup                 // Goroutines of the current connection
+	disconnected    chan struct{}                  // Signal für Verbindungsverlust
+	wakeup          chan struct{}                  // Signal vom UDP Discovery Listener
+	reconnect       chan struct{}                  // Signal nach Config-Reload mit neuer URL/ID
+	intervalChanged chan struct{}                  // Signal nach Config-Reload mit neuem Intervall
+	replay          chan struct{}                  // Signal: gepufferte Stats nachsenden
+	spool           *spool.Queue                   // Offline-Puffer für Stats, nil wenn deaktiviert
+	endpoints       endpointSet                    // Navigator-Endpunkte für Failover
+	discoveredURL   atomic.Value                   // Per Discovery übernommene URL (string)
+	lastWakeup      atomic.Int64                   // UnixNano des letzten Discovery-Wakeups
+	certs           certReloader                   // Client-Zertifikat für mTLS, wird bei Rotation neu geladen
+	authenticated   atomic.Bool                    // Enrollment und Challenge/Response erfolgreich
+	unsaved         atomic.Pointer[unsavedCred]    // Credential aus dem Enrollment, noch nicht gespeichert
+	sessions        sessionRegistry                // Laufende Commands und Log-Transfers
+	shells          liveRegistry[*commands.Shell]  // Offene PTY-Shells
+	uploads         liveRegistry[*commands.Upload] // Laufende Datei-Uploads
+	pool            workerPool                     // Begrenzt gleichzeitige Commands und Log-Transfers
+	follows         workerPool                     // Eigene Plätze für read_log im Follow-Modus
+	shellSlots      workerPool                     // Eigene Plätze für PTY-Shells
+	uploadSlots     workerPool                     // Eigene Plätze für Datei-Uploads
+	audit           *audit.Journal                 // Audit-Journal, nil wenn deaktiviert
+	stateMutex      sync.RWMutex
+	state           State
+	stateSince      time.Time
 }
 
 // Command represents a command from the Navigator
@@ -39,83 +70,217 @@ type Message struct {
 	Type      string                 `json:"type"`
 	MateID    string                 `json:"mate_id"`
 	Data      interface{}            `json:"data,omitempty"`
+	Backfill  bool                   `json:"backfill,omitempty"` // Stats collected while offline
 	Timestamp time.Time              `json:"timestamp"`
 }
 
 // NewClient creates a new WebSocket client
 func NewClient(cfg *config.Config, monitor *hardware.Monitor) *Client {
-	return &Client{
-		config:       cfg,
-		monitor:      monitor,
-		commands:     make(chan Command, 10),
-		done:         make(chan struct{}),
-		disconnected: make(chan struct{}),
-		wakeup:       make(chan struct{}, 1),
+	c := &Client{
+		monitor:         monitor,
+		logger:          logging.For("websocket"),
+		commands:        make(chan Command, 10),
+		done:            make(chan struct{}),
+		disconnected:    make(chan struct{}, 1),
+		wakeup:          make(chan struct{}, 1),
+		reconnect:       make(chan struct{}, 1),
+		intervalChanged: make(chan struct{}, 1),
+		replay:          make(chan struct{}, 1),
 	}
+	c.config.Store(cfg)
+	c.spool = c.openSpool(cfg)
+	c.audit = c.openAudit(cfg)
+	c.endpoints.sync(c.navigatorEndpoints())
+	c.stateSince = time.Now()
+	return c
 }
 
-// Connect establishes a WebSocket connection to the Navigator
-func (c *Client) Connect() error {
-	url := fmt.Sprintf("%s/%s", c.config.Navigator.URL, c.config.Mate.ID)
-	log.Printf("Connecting to Fleet Navigator at %s", url)
+// cfg returns the currently active configuration
+func (c *Client) cfg() *config.Config {
+	return c.config.Load()
+}
+
+// UpdateConfig atomically swaps the configuration. The stats ticker picks
+// up a changed interval immediately; the connection is only re-established
+// when the Navigator URL or the mate ID changed.
+func (c *Client) UpdateConfig(cfg *config.Config) {
+	old := c.config.Swap(cfg)
+
+	if old.Monitoring.Interval != cfg.Monitoring.Interval {
+		c.logger.Info("Monitoring interval changed", "old", old.Monitoring.Interval, "new", cfg.Monitoring.Interval)
+		signal(c.intervalChanged)
+	}
+
+	if !slices.Equal(old.Navigator.Endpoints(), cfg.Navigator.Endpoints()) || old.Mate.ID != cfg.Mate.ID {
+		c.logger.Info("Navigator endpoints or mate ID changed, reconnecting",
+			"urls", cfg.Navigator.Endpoints(), "mate_id", cfg.Mate.ID)
+		signal(c.reconnect)
+	}
+}
+
+// signal performs a non-blocking send on a buffered notification channel
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Connect establishes a WebSocket connection to the Navigator endpoint
+// selected by Run (the preferred one if Run is not used). The returned
+// connection is handed on explicitly, c.conn is only used for writes and
+// may be dropped by Stop at any time.
+func (c *Client) Connect() (*websocket.Conn, error) {
+	endpoint := c.endpoints.current()
+	if endpoint == "" {
+		endpoint = c.endpoints.preferred()
+	}
+	url := fmt.Sprintf("%s/%s", endpoint, c.cfg().Mate.ID)
+	c.logger.Info("Connecting to Fleet Navigator", "url", url)
+
+	tlsConfig, err := buildTLSConfig(c.cfg().Navigator.TLS, &c.certs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
 
-	dialer := websocket.DefaultDialer
+	dialer := *websocket.DefaultDialer
 	dialer.HandshakeTimeout = 10 * time.Second
+	dialer.TLSClientConfig = tlsConfig
 
 	conn, _, err := dialer.Dial(url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
+	// A Stop during the dial must not be undone by publishing the
+	// connection afterwards
+	c.connMutex.Lock()
+	select {
+	case <-c.done:
+		c.connMutex.Unlock()
+		conn.Close()
+		return nil, fmt.Errorf("client stopped")
+	default:
+	}
 	c.conn = conn
-	log.Printf("Connected to Fleet Navigator")
+	c.connMutex.Unlock()
+	c.logger.Info("Connected to Fleet Navigator")
+
+	// Enrollment and challenge/response authentication
+	if err := c.authenticate(conn); err != nil {
+		c.closeConnection()
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
 
 	// Send registration message
 	if err := c.sendRegistration(); err != nil {
-		c.conn.Close()
-		return fmt.Errorf("failed to register: %w", err)
+		c.closeConnection()
+		return nil, fmt.Errorf("failed to register: %w", err)
 	}
 
-	return nil
+	return conn, nil
 }
 
-// Start begins the client operations
-func (c *Client) Start() error {
-	if c.conn == nil {
+// Start begins the client operations on conn
+func (c *Client) Start(conn *websocket.Conn) error {
+	if conn == nil {
 		return fmt.Errorf("not connected")
 	}
 
+	connDone := c.connDone
+
+	c.connWG.Add(5)
+
 	// Start reading commands from Navigator
-	go c.readCommands()
+	go func() {
+		defer c.connWG.Done()
+		c.readCommands(conn, connDone)
+	}()
 
-	// Start sending hardware stats
-	go c.sendStats()
+	// Replay stats buffered while offline
+	go func() {
+		defer c.connWG.Done()
+		c.replayStats(connDone)
+	}()
+	signal(c.replay)
+
+	// Fall back to the preferred endpoint once it is reachable again
+	go func() {
+		defer c.connWG.Done()
+		c.watchFailback(connDone)
+	}()
+
+	// Store a credential that could not be saved after enrollment
+	go func() {
+		defer c.connWG.Done()
+		c.retryCredentialSave(connDone)
+	}()
 
 	// Start sending heartbeats
-	go c.sendHeartbeats()
+	go func() {
+		defer c.connWG.Done()
+		c.sendHeartbeats(connDone)
+	}()
 
 	return nil
 }
 
-// Stop closes the connection and stops all operations
+// Stop closes the connection and stops all operations. It is safe to
+// call Stop multiple times and concurrently with reconnects.
 func (c *Client) Stop() {
-	close(c.done)
+	c.stopOnce.Do(func() {
+		c.setState(StateStopping)
+		close(c.done)
+		c.sessions.cancelAll()
+		c.closeConnection()
+		c.logger.Info("Fleet Mate stopped")
+	})
+}
+
+// teardown stops the goroutines of the current connection and waits for them
+func (c *Client) teardown() {
+	close(c.connDone)
+	c.closeConnection()
+	c.connWG.Wait()
+
+	// Followed logs, shells and uploads only make sense for the connection
+	// that asked for them. A cancelled upload keeps its partial file, so
+	// the Navigator can resume it after reconnecting.
+	c.sessions.cancelKind(sessionFollow)
+	c.sessions.cancelKind(sessionShell)
+	c.sessions.cancelKind(sessionUpload)
+
+	// Stale disconnect signal of the old connection
+	select {
+	case <-c.disconnected:
+	default:
+	}
+}
+
+// closeConnection sends a close frame and drops the current connection
+func (c *Client) closeConnection() {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
 	if c.conn != nil {
 		c.conn.WriteMessage(websocket.CloseMessage,
 			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 		c.conn.Close()
+		c.conn = nil
 	}
-	log.Println("Fleet Mate stopped")
 }
 
 // sendRegistration sends registration information to Navigator
 func (c *Client) sendRegistration() error {
 	msg := Message{
 		Type:   "register",
-		MateID: c.config.Mate.ID,
+		MateID: c.cfg().Mate.ID,
 		Data: map[string]interface{}{
-			"name":        c.config.Mate.Name,
-			"description": c.config.Mate.Description,
+			"name":            c.cfg().Mate.Name,
+			"description":     c.cfg().Mate.Description,
+			"identity_source": c.cfg().Mate.IDSource,
+			"authenticated":   c.authenticated.Load(),
+			"endpoint":        c.endpoints.current(),
 		},
 		Timestamp: time.Now(),
 	}
@@ -123,38 +288,38 @@ func (c *Client) sendRegistration() error {
 	return c.sendMessage(msg)
 }
 
-// sendStats periodically sends hardware statistics
+// sendStats periodically collects hardware statistics for the whole
+// lifetime of the client. Samples are sent live while registered and
+// buffered on disk otherwise.
 func (c *Client) sendStats() {
-	ticker := time.NewTicker(c.config.Monitoring.Interval)
+	ticker := time.NewTicker(c.cfg().Monitoring.Interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-c.done:
 			return
+		case <-c.intervalChanged:
+			ticker.Reset(c.cfg().Monitoring.Interval)
 		case <-ticker.C:
-			stats, err := c.monitor.Collect()
-			if err != nil {
-				log.Printf("Failed to collect stats: %v", err)
+			state, _ := c.State()
+			if c.spool == nil && state != StateRegistered {
 				continue
 			}
 
-			msg := Message{
-				Type:   "stats",
-				MateID: c.config.Mate.ID,
-				Data:   stats,
-				Timestamp: time.Now(),
+			stats, err := c.monitor.Collect()
+			if err != nil {
+				c.logger.Warn("Failed to collect stats", "error", err)
+				continue
 			}
 
-			if err := c.sendMessage(msg); err != nil {
-				log.Printf("Failed to send stats: %v", err)
-			}
+			c.publishStats(stats)
 		}
 	}
 }
 
 // sendHeartbeats periodically sends heartbeat messages
-func (c *Client) sendHeartbeats() {
+func (c *Client) sendHeartbeats(connDone chan struct{}) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
@@ -162,22 +327,28 @@ func (c *Client) sendHeartbeats() {
 		select {
 		case <-c.done:
 			return
+		case <-connDone:
+			return
 		case <-ticker.C:
 			msg := Message{
 				Type:   "heartbeat",
-				MateID: c.config.Mate.ID,
+				MateID: c.cfg().Mate.ID,
+				Data: map[string]interface{}{
+					"endpoint":   c.endpoints.activeURL(),
+					"audit_head": c.audit.Head(),
+				},
 				Timestamp: time.Now(),
 			}
 
 			if err := c.sendMessage(msg); err != nil {
-				log.Printf("Failed to send heartbeat: %v", err)
+				c.logger.Warn("Failed to send heartbeat", "error", err)
 			}
 		}
 	}
 }
 
 // readCommands reads commands from the Navigator
-func (c *Client) readCommands() {
+func (c *Client) readCommands(conn *websocket.Conn, connDone chan struct{}) {
 	errorCount := 0
 	maxConsecutiveErrors := 5 // Nach 5 aufeinanderfolgenden Fehlern reconnecten
 
@@ -185,38 +356,54 @@ func (c *Client) readCommands() {
 		select {
 		case <-c.done:
 			return
+		case <-connDone:
+			return
 		default:
 			var cmd Command
-			err := c.conn.ReadJSON(&cmd)
+			msgType, data, err := conn.ReadMessage()
+			if err == nil && msgType == websocket.BinaryMessage {
+				// Binary frames only carry file chunks
+				errorCount = 0
+				c.handleBinaryFrame(data)
+				continue
+			}
+			if err == nil {
+				err = json.Unmarshal(data, &cmd)
+			}
 			if err != nil {
+				// Verbindung wurde absichtlich abgebaut (Stop oder Config-Reload)
+				select {
+				case <-connDone:
+					return
+				case <-c.done:
+					return
+				default:
+				}
+
 				errorCount++
 
 				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-					log.Println("Connection closed normally")
+					c.logger.Info("Connection closed normally")
+					signal(c.disconnected)
 					return
 				}
 
 				// Bei broken pipe oder zu vielen Fehlern: Verbindung ist tot
 				if websocket.IsUnexpectedCloseError(err) || errorCount >= maxConsecutiveErrors {
-					log.Printf("Connection lost after %d errors, triggering reconnect: %v", errorCount, err)
-					c.conn.Close()
-					c.conn = nil
-					// Signal Disconnection für Reconnect-Logik
-					select {
-					case c.disconnected <- struct{}{}:
-					default:
-					}
+					c.logger.Warn("Connection lost, triggering reconnect", "errors", errorCount, "error", err)
+					// Signal Disconnection für Reconnect-Logik, Abbau macht Run
+					signal(c.disconnected)
 					return
 				}
 
-				log.Printf("Failed to read command: %v", err)
+				c.logger.Warn("Failed to read command", "error", err)
 				time.Sleep(time.Second)
 				continue
 			}
 
 			// Erfolgreicher Read → Error Counter zurücksetzen
 			errorCount = 0
-			log.Printf("Received command: %s", cmd.Type)
+			c.logger.Debug("Received command", "type", cmd.Type)
 			c.handleCommand(cmd)
 		}
 	}
@@ -224,6 +411,11 @@ func (c *Client) readCommands() {
 
 // handleCommand processes commands from the Navigator
 func (c *Client) handleCommand(cmd Command) {
+	if privilegedCommands[cmd.Type] && !c.authenticated.Load() {
+		c.rejectUnauthenticated(cmd)
+		return
+	}
+
 	switch cmd.Type {
 	case "ping":
 		c.sendPong()
@@ -233,14 +425,33 @@ func (c *Client) handleCommand(cmd Command) {
 		c.handleReadLog(cmd.Payload)
 	case "execute_command":
 		c.handleExecuteCommand(cmd.Payload)
+	case "cancel_session":
+		c.handleCancelSession(cmd.Payload)
+	case "shell_open":
+		c.handleShellOpen(cmd.Payload)
+	case "shell_input":
+		c.handleShellInput(cmd.Payload)
+	case "shell_resize":
+		c.handleShellResize(cmd.Payload)
+	case "shell_close":
+		c.handleShellClose(cmd.Payload)
+	case "file_download":
+		c.handleFileDownload(cmd.Payload)
+	case "file_upload":
+		c.handleFileUpload(cmd.Payload)
+	case "file_chunk":
+		c.handleFileChunk(cmd.Payload)
+	case "audit_query":
+		c.handleAuditQuery(cmd.Payload)
 	case "shutdown":
-		log.Println("Shutdown command received")
+		c.logger.Info("Shutdown command received")
+		c.auditEvent("shutdown", getStringFromPayload(cmd.Payload, "sessionId", ""), "", audit.Allowed, "", "")
 		go func() {
 			time.Sleep(time.Second)
 			c.Stop()
 		}()
 	default:
-		log.Printf("Unknown command type: %s", cmd.Type)
+		c.logger.Warn("Unknown command type", "type", cmd.Type)
 	}
 }
 
@@ -249,32 +460,66 @@ func (c *Client) handleReadLog(payload map[string]interface{}) {
 	// Parse request - WICHTIG: sessionId aus Payload lesen!
 	request := commands.ReadLogRequest{
 		SessionID: getStringFromPayload(payload, "sessionId", ""),
-		Path:      getStringFromPayload(payload, "path", "/var/log/syslog"),
+		Path:      getStringFromPayload(payload, "path", ""),
 		Mode:      getStringFromPayload(payload, "mode", "smart"),
-		Lines:     getIntFromPayload(payload, "lines", 1000),
+		Lines:     getIntFromPayload(payload, "lines", 0),
+		Order:     getStringFromPayload(payload, "order", commands.OrderOldest),
+
+		Follow:      getBoolFromPayload(payload, "follow", false),
+		IdleTimeout: getIntFromPayload(payload, "idleTimeout", 0),
+
+		Include:    getStringFromPayload(payload, "include", ""),
+		Exclude:    getStringFromPayload(payload, "exclude", ""),
+		IgnoreCase: getBoolFromPayload(payload, "ignoreCase", false),
+		Since:      getStringFromPayload(payload, "since", ""),
+		Until:      getStringFromPayload(payload, "until", ""),
+		Before:     getIntFromPayload(payload, "before", 0),
+		After:      getIntFromPayload(payload, "after", 0),
+
+		Source:   getStringFromPayload(payload, "source", commands.SourceFile),
+		Unit:     getStringFromPayload(payload, "unit", ""),
+		Priority: getStringFromPayload(payload, "priority", ""),
+		Boot:     getStringFromPayload(payload, "boot", ""),
+	}
+
+	// A follow without ID couldn't be cancelled, nor stopped on disconnect
+	if request.Follow && request.SessionID == "" {
+		c.rejectSession("read_log", "", errors.New("follow requires a sessionId"))
+		return
+	}
+
+	// Without a path, syslog is read; journald-only hosts have none, there
+	// the journal is the default
+	if request.Path == "" && request.Source == commands.SourceFile {
+		request.Path = "/var/log/syslog"
+		if _, err := os.Stat(request.Path); errors.Is(err, os.ErrNotExist) && c.cfg().Logs.Journal {
+			request.Source = commands.SourceJournal
+		}
+	}
+
+	access, err := accessList(c.cfg(), c.cfg().Logs.Allow, c.cfg().Logs.Deny)
+	if err != nil {
+		c.rejectSession("read_log", request.SessionID, err)
+		return
 	}
 
 	// Create log reader
-	logReader := commands.NewLogReader(c.config.Mate.ID)
+	logReader := commands.NewLogReader(c.cfg().Mate.ID, access, c.audit)
+	logReader.MaxOutput = int64(c.cfg().Commands.MaxOutputSize) * 1024 * 1024
+	logReader.Journal = c.cfg().Logs.Journal
 
-	// Execute log reading with callback to send messages
-	go func() {
-		err := logReader.HandleReadLogCommand(request, func(msgType string, data interface{}) {
-			msg := Message{
-				Type:   msgType,
-				MateID: c.config.Mate.ID,
-				Data:   data,
-				Timestamp: time.Now(),
-			}
-			if err := c.sendMessage(msg); err != nil {
-				log.Printf("Failed to send %s message: %v", msgType, err)
-			}
-		})
+	kind := sessionLog
+	if request.Follow {
+		kind = sessionFollow
+	}
 
+	// Execute log reading with callback to send messages
+	c.startSession("read_log", request.SessionID, kind, func(ctx context.Context) {
+		err := logReader.HandleReadLogCommand(ctx, request, c.sendData)
 		if err != nil {
-			log.Printf("Failed to read log file: %v", err)
+			c.logger.Error("Failed to read log file", "error", err)
 		}
-	}()
+	})
 }
 
 // handleExecuteCommand processes the execute_command command
@@ -284,6 +529,9 @@ func (c *Client) handleExecuteCommand(payload map[string]interface{}) {
 	command := getStringFromPayload(payload, "command", "")
 	workingDir := getStringFromPayload(payload, "workingDir", "/tmp")
 	timeout := getIntFromPayload(payload, "timeout", 300)
+	flushMode := getStringFromPayload(payload, "flushMode", commands.FlushLine)
+	flushInterval := getIntFromPayload(payload, "flushInterval", 0)
+	explain := getBoolFromPayload(payload, "explain", false)
 
 	// Parse args array
 	var args []string
@@ -303,31 +551,175 @@ func (c *Client) handleExecuteCommand(payload map[string]interface{}) {
 		Args:       args,
 		WorkingDir: workingDir,
 		Timeout:    timeout,
+		Explain:    explain,
+
+		FlushMode:     flushMode,
+		FlushInterval: flushInterval,
 	}
 
 	// Create command executor
-	executor := commands.NewCommandExecutor(c.config.Mate.ID)
+	executor := commands.NewCommandExecutor(c.cfg().Mate.ID, c.cfg().Commands.Policy, c.audit)
+	executor.MaxOutput = int64(c.cfg().Commands.MaxOutputSize) * 1024 * 1024
 
 	// Execute command with callback to send messages
-	go func() {
-		err := executor.HandleExecuteCommand(request, func(msgType string, data interface{}) {
-			msg := Message{
-				Type:   msgType,
-				MateID: c.config.Mate.ID,
-				Data:   data,
-				Timestamp: time.Now(),
-			}
-			if err := c.sendMessage(msg); err != nil {
-				log.Printf("Failed to send %s message: %v", msgType, err)
-			}
+	c.startSession("execute_command", sessionID, sessionCommand, func(ctx context.Context) {
+		err := executor.HandleExecuteCommand(ctx, request, c.sendData)
+		if err != nil {
+			c.logger.Error("Failed to execute command", "error", err)
+		}
+	})
+}
+
+// startSession registers a session and runs it in the worker pool. When
+// all slots and queue places are taken, the session is rejected as busy.
+// A session cancelled while queued still runs, so it reports its final
+// status like any other cancelled session. Follow sessions run until they
+// go idle, so they have their own slots (logs.max_follows, no queue) and
+// can't lock out commands.
+func (c *Client) startSession(command, sessionID, kind string, run func(ctx context.Context)) {
+	ctx, finish, err := c.sessions.start(sessionID, kind)
+	if err != nil {
+		c.rejectSession(command, sessionID, err)
+		return
+	}
+
+	pool, limits := c.slots(kind)
+	ready, position, err := pool.enqueue(limits())
+	if err != nil {
+		finish()
+		c.rejectBusy(command, sessionID, err)
+		return
+	}
+	if position > 0 {
+		c.logger.Info("Session queued", "command", command, "session", sessionID, "position", position)
+		c.sendData("session_queued", map[string]interface{}{
+			"sessionId": sessionID,
+			"command":   command,
+			"position":  position,
 		})
+	}
 
-		if err != nil {
-			log.Printf("Failed to execute command: %v", err)
+	go func() {
+		defer finish()
+		if pool.wait(ctx, ready) {
+			defer func() {
+				maxRunning, _ := limits()
+				pool.release(maxRunning)
+			}()
 		}
+		run(ctx)
 	}()
 }
 
+// startInteractive registers a shell or upload session and takes one of
+// its slots. These sessions wait for input from the Navigator, so like
+// follows they have their own slots without a queue. The returned finish
+// ends the session and frees the slot; ok is false if the session was
+// rejected.
+func (c *Client) startInteractive(command, sessionID, kind string) (ctx context.Context, finish func(), ok bool) {
+	ctx, end, err := c.sessions.start(sessionID, kind)
+	if err != nil {
+		c.rejectSession(command, sessionID, err)
+		return nil, nil, false
+	}
+
+	pool, limits := c.slots(kind)
+	if _, _, err := pool.enqueue(limits()); err != nil {
+		end()
+		c.rejectBusy(command, sessionID, err)
+		return nil, nil, false
+	}
+	finish = func() {
+		end()
+		maxRunning, _ := limits()
+		pool.release(maxRunning)
+	}
+	return ctx, finish, true
+}
+
+// slots returns the worker pool of a session kind and a function returning
+// its current limits (running, queued)
+func (c *Client) slots(kind string) (*workerPool, func() (int, int)) {
+	switch kind {
+	case sessionFollow:
+		return &c.follows, func() (int, int) { return c.cfg().Logs.MaxFollows, 0 }
+	case sessionShell:
+		return &c.shellSlots, func() (int, int) { return c.cfg().Commands.MaxShells, 0 }
+	case sessionUpload:
+		return &c.uploadSlots, func() (int, int) { return c.cfg().Files.MaxUploads, 0 }
+	}
+	return &c.pool, func() (int, int) {
+		return c.cfg().Commands.MaxSessions, c.cfg().Commands.QueueSize
+	}
+}
+
+// handleCancelSession stops a running command or log transfer. The session
+// itself reports the final command_complete or log_complete.
+func (c *Client) handleCancelSession(payload map[string]interface{}) {
+	sessionID := getStringFromPayload(payload, "sessionId", "")
+
+	s, ok := c.sessions.cancel(sessionID)
+	if !ok {
+		c.logger.Warn("cancel_session for unknown session", "session", sessionID)
+		c.sendData("cancel_result", map[string]interface{}{
+			"sessionId": sessionID,
+			"success":   false,
+			"error":     "no such session",
+		})
+		c.auditEvent("cancel_session", "", sessionID, audit.Allowed, "failed", "no such session")
+		return
+	}
+
+	c.logger.Info("Session cancelled by Navigator", "session", sessionID, "kind", s.kind,
+		"running", time.Since(s.started).Round(time.Millisecond))
+	c.sendData("cancel_result", map[string]interface{}{
+		"sessionId": sessionID,
+		"success":   true,
+	})
+	c.auditEvent("cancel_session", "", sessionID, audit.Allowed, commands.StatusCompleted, "")
+}
+
+// rejectSession tells the Navigator that a session could not be started
+func (c *Client) rejectSession(command, sessionID string, err error) {
+	c.logger.Warn("Rejecting session", "command", command, "session", sessionID, "error", err)
+	c.sendData("command_rejected", map[string]interface{}{
+		"sessionId": sessionID,
+		"command":   command,
+		"reason":    err.Error(),
+	})
+	c.auditEvent(command, sessionID, "", audit.Rejected, commands.StatusRejected, err.Error())
+}
+
+// rejectBusy tells the Navigator that the session limits are exhausted
+func (c *Client) rejectBusy(command, sessionID string, err error) {
+	c.logger.Warn("Rejecting session, mate is busy", "command", command, "session", sessionID, "error", err)
+	data := map[string]interface{}{
+		"sessionId": sessionID,
+		"command":   command,
+		"reason":    err.Error(),
+	}
+	var busy *busyError
+	if errors.As(err, &busy) {
+		data["running"] = busy.running
+		data["queued"] = busy.queued
+	}
+	c.sendData("busy", data)
+	c.auditEvent(command, sessionID, "", audit.Rejected, "busy", err.Error())
+}
+
+// sendData wraps data in a Message, used as callback by the command handlers
+func (c *Client) sendData(msgType string, data interface{}) {
+	msg := Message{
+		Type:      msgType,
+		MateID:    c.cfg().Mate.ID,
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+	if err := c.sendMessage(msg); err != nil {
+		c.logger.Warn("Failed to send message", "type", msgType, "error", err)
+	}
+}
+
 // Helper functions to safely extract values from payload
 func getStringFromPayload(payload map[string]interface{}, key string, defaultValue string) string {
 	if val, ok := payload[key]; ok {
@@ -350,11 +742,20 @@ func getIntFromPayload(payload map[string]interface{}, key string, defaultValue
 	return defaultValue
 }
 
+func getBoolFromPayload(payload map[string]interface{}, key string, defaultValue bool) bool {
+	if val, ok := payload[key]; ok {
+		if b, ok := val.(bool); ok {
+			return b
+		}
+	}
+	return defaultValue
+}
+
 // sendPong responds to ping with pong
 func (c *Client) sendPong() {
 	msg := Message{
 		Type:   "pong",
-		MateID: c.config.Mate.ID,
+		MateID: c.cfg().Mate.ID,
 		Timestamp: time.Now(),
 	}
 	c.sendMessage(msg)
@@ -364,13 +765,13 @@ func (c *Client) sendPong() {
 func (c *Client) sendStatsNow() {
 	stats, err := c.monitor.Collect()
 	if err != nil {
-		log.Printf("Failed to collect stats: %v", err)
+		c.logger.Warn("Failed to collect stats", "error", err)
 		return
 	}
 
 	msg := Message{
 		Type:   "stats",
-		MateID: c.config.Mate.ID,
+		MateID: c.cfg().Mate.ID,
 		Data:   stats,
 		Timestamp: time.Now(),
 	}
@@ -380,21 +781,21 @@ func (c *Client) sendStatsNow() {
 
 // sendMessage sends a message to the Navigator
 func (c *Client) sendMessage(msg Message) error {
-	if c.conn == nil {
-		return fmt.Errorf("not connected")
-	}
-
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	log.Printf("Sending message: type=%s, size=%d bytes", msg.Type, len(data))
+	c.logger.Debug("Sending message", "type", msg.Type, "bytes", len(data))
 
 	// Lock to prevent concurrent writes to WebSocket
 	c.connMutex.Lock()
 	defer c.connMutex.Unlock()
 
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
 	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
@@ -402,124 +803,178 @@ func (c *Client) sendMessage(msg Message) error {
 	return nil
 }
 
-// startUDPDiscoveryListener startet einen UDP-Listener für Navigator Discovery
-func (c *Client) startUDPDiscoveryListener() {
-	addr := net.UDPAddr{
-		Port: 9090,
-		IP:   net.ParseIP("0.0.0.0"),
-	}
+// Run starts the client with automatic reconnection. The connection
+// lifecycle is a small state machine:
+//
+//	connecting → registered → (disconnect) → listening → connecting
+//	connecting → (failure) → connecting next endpoint
+//	connecting → (all endpoints failed) → backoff → connecting
+//	connecting → (max attempts) → listening → connecting
+//	any state  → (Stop) → stopping → stopped
+//
+// With several endpoints a lost connection fails over to the next one
+// immediately; listener mode is only used when there is nowhere else to go.
+func (c *Client) Run() error {
+	defer c.setState(StateStopped)
 
-	conn, err := net.ListenUDP("udp", &addr)
-	if err != nil {
-		log.Printf("Failed to start UDP discovery listener: %v", err)
-		return
-	}
-	defer conn.Close()
+	// Starte UDP Discovery Listener (läuft parallel)
+	go c.startUDPDiscoveryListener()
+	go c.startMDNSDiscovery()
 
-	log.Println("UDP Discovery Listener started on port 9090")
+	// Stats laufen unabhängig von der Verbindung (Offline-Puffer)
+	go c.sendStats()
+
+	var retry backoff
+	attemptCount := 0
 
-	buffer := make([]byte, 1024)
 	for {
 		select {
 		case <-c.done:
-			return
+			return nil
 		default:
-			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-			n, remoteAddr, err := conn.ReadFromUDP(buffer)
-			if err != nil {
-				// Timeout ist OK, einfach weiter warten
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					continue
-				}
-				log.Printf("UDP read error: %v", err)
-				continue
-			}
-
-			message := strings.TrimSpace(string(buffer[:n]))
-			log.Printf("Received UDP broadcast from %s: %s", remoteAddr.IP, message)
+		}
 
-			// Prüfe ob es ein Navigator Discovery Signal ist
-			if message == "FLEET_NAVIGATOR_READY" {
-				log.Println("Navigator discovered! Triggering reconnect...")
-				// Signal zum Reconnect senden (non-blocking)
-				select {
-				case c.wakeup <- struct{}{}:
-				default:
-				}
+		nav := c.cfg().Navigator
+		urls := c.navigatorEndpoints()
+		if len(urls) == 0 {
+			// Keine URL konfiguriert → auf verifizierte Discovery warten
+			c.logger.Info("No Navigator URL configured, waiting for a signed discovery announcement")
+			if !c.listen(0) {
+				return nil
 			}
+			continue
 		}
-	}
-}
-
-// Run starts the client with automatic reconnection
-func (c *Client) Run() error {
-	attemptCount := 0
-	maxAttempts := c.config.Navigator.MaxReconnectAttempts
+		c.endpoints.sync(urls)
 
-	// Starte UDP Discovery Listener (läuft parallel)
-	go c.startUDPDiscoveryListener()
-
-	for {
-		// Neue Channels für diese Verbindung erstellen
-		c.done = make(chan struct{})
-		c.disconnected = make(chan struct{}, 1)
-
-		err := c.Connect()
-		if err != nil {
+		index, endpoint, ok := c.endpoints.pick(nav.Selection)
+		if !ok {
+			// Alle Endpunkte in dieser Runde fehlgeschlagen
 			attemptCount++
+			c.endpoints.newRound()
 
 			// Bei zu vielen Fehlversuchen: In Listener Mode gehen
-			if maxAttempts > 0 && attemptCount >= maxAttempts {
-				log.Printf("Max reconnect attempts reached. Entering listener mode...")
-				log.Println("Waiting for Navigator discovery signal...")
-
-				// Warte auf UDP Discovery Signal
-				select {
-				case <-c.wakeup:
-					log.Println("Wakeup signal received, attempting reconnect...")
-					attemptCount = 0 // Reset counter
-					continue
-				case <-c.done:
+			if nav.MaxReconnectAttempts > 0 && attemptCount >= nav.MaxReconnectAttempts {
+				c.logger.Warn("Max reconnect attempts reached, entering listener mode", "attempts", attemptCount)
+				if !c.listen(0) {
 					return nil
 				}
+				attemptCount = 0
+				retry.reset()
+				continue
 			}
 
-			log.Printf("Connection failed (attempt %d): %v", attemptCount, err)
-			log.Printf("Retrying in %s...", c.config.Navigator.ReconnectInterval)
-			time.Sleep(c.config.Navigator.ReconnectInterval)
+			delay := retry.next(nav)
+			c.logger.Warn("All Navigator endpoints failed", "attempt", attemptCount, "retry_in", delay.Round(time.Millisecond))
+			if !c.waitBackoff(delay) {
+				return nil
+			}
+			continue
+		}
+
+		c.setState(StateConnecting)
+		c.connDone = make(chan struct{})
+
+		conn, err := c.Connect()
+		if err != nil {
+			c.logger.Warn("Connection failed", "url", endpoint, "error", err)
+			c.endpoints.failed(index)
 			continue
 		}
 
 		attemptCount = 0
-		log.Println("Connected successfully")
+		retry.reset()
+		c.endpoints.connected(index)
 
-		if err := c.Start(); err != nil {
-			log.Printf("Failed to start client: %v", err)
-			time.Sleep(c.config.Navigator.ReconnectInterval)
+		if err := c.Start(conn); err != nil {
+			c.logger.Error("Failed to start client", "error", err)
+			c.teardown()
 			continue
 		}
+		c.setState(StateRegistered)
+		c.logger.Info("Connected successfully")
+
+		// Discovery-Signale aus der Zeit vor der Verbindung verwerfen
+		select {
+		case <-c.wakeup:
+		default:
+		}
 
-		// Warte auf Disconnect, Wakeup oder Done Signal
+		// Warte auf Disconnect, Reconnect oder Done Signal
 		select {
+		case <-c.reconnect:
+			// Config-Reload oder Failback → sofort neu verbinden
+			c.teardown()
+			c.endpoints.disconnected()
+
 		case <-c.disconnected:
+			c.teardown()
+			c.endpoints.disconnected()
+
+			// Mehrere Endpunkte: sofort auf den nächsten wechseln
+			if len(c.navigatorEndpoints()) > 1 {
+				c.logger.Warn("Connection lost, failing over", "url", endpoint)
+				c.endpoints.failed(index)
+				continue
+			}
+
 			// Verbindung verloren → In Listener Mode gehen
-			log.Println("Connection lost, entering listener mode...")
-			log.Println("Waiting for Navigator discovery signal...")
-
-			// Warte auf UDP Discovery Signal
-			select {
-			case <-c.wakeup:
-				log.Println("Wakeup signal received, attempting reconnect...")
-			case <-time.After(5 * time.Minute):
-				log.Println("No discovery signal received for 5 minutes, trying reconnect anyway...")
-			case <-c.done:
+			c.logger.Warn("Connection lost, entering listener mode")
+			if !c.listen(c.cfg().Navigator.ListenerTimeout) {
 				return nil
 			}
 
 		case <-c.done:
 			// Manueller Stop
-			log.Println("Client stopped")
+			c.teardown()
+			c.logger.Info("Client stopped")
 			return nil
 		}
 	}
 }
+
+// waitBackoff sleeps for delay unless a config change or Stop interrupts it.
+// It returns false if the client was stopped.
+func (c *Client) waitBackoff(delay time.Duration) bool {
+	c.setState(StateBackoff)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-c.reconnect:
+		// Neue URL/ID aus Config-Reload sofort versuchen
+	case <-c.wakeup:
+		c.logger.Info("Wakeup signal received, attempting reconnect")
+	case <-c.done:
+		return false
+	}
+	return true
+}
+
+// listen waits for a Navigator discovery signal. A timeout of 0 waits
+// until a signal or config change arrives. It returns false if the client
+// was stopped.
+func (c *Client) listen(timeout time.Duration) bool {
+	c.setState(StateListening)
+	c.logger.Info("Waiting for Navigator discovery signal", "timeout", timeout)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-c.wakeup:
+		c.logger.Info("Wakeup signal received, attempting reconnect")
+	case <-c.reconnect:
+		c.logger.Info("Configuration changed, attempting reconnect")
+	case <-expired:
+		c.logger.Info("No discovery signal received, trying reconnect anyway", "waited", timeout)
+	case <-c.done:
+		return false
+	}
+	return true
+}
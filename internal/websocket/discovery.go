@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/discovery"
+)
+
+// minWakeupInterval limits how often announcements may trigger a reconnect
+const minWakeupInterval = 10 * time.Second
+
+// startUDPDiscoveryListener startet einen UDP-Listener für Navigator Discovery
+func (c *Client) startUDPDiscoveryListener() {
+	dc := c.cfg().Discovery
+	if !dc.UDP.Enabled {
+		return
+	}
+
+	if dc.Secret == "" && !dc.AllowUnsigned {
+		c.logger.Warn("UDP discovery has no discovery.secret, announcements will be ignored")
+	}
+
+	verifier := &discovery.Verifier{Secret: dc.Secret, AllowUnsigned: dc.AllowUnsigned}
+	addr := net.JoinHostPort(dc.UDP.BindAddress, strconv.Itoa(dc.UDP.Port))
+
+	if err := discovery.ListenUDP(addr, verifier, c.done, c.logger, c.handleAnnouncement); err != nil {
+		c.logger.Error("Failed to start UDP discovery listener", "error", err)
+	}
+}
+
+// startMDNSDiscovery browses for Navigator services via multicast DNS
+func (c *Client) startMDNSDiscovery() {
+	dc := c.cfg().Discovery
+	if !dc.MDNS.Enabled {
+		return
+	}
+
+	verifier := &discovery.Verifier{Secret: dc.Secret, AllowUnsigned: dc.AllowUnsigned}
+	service := dc.MDNS.Service + "." + dc.MDNS.Domain
+
+	if err := discovery.BrowseMDNS(service, dc.MDNS.Interval, verifier, c.done, c.logger, c.handleAnnouncement); err != nil {
+		c.logger.Error("Failed to start mDNS discovery", "error", err)
+	}
+}
+
+// handleAnnouncement wakes the connection loop for an accepted UDP or mDNS
+// announcement. A mate without configured URL adopts the announced URL, but only from a
+// verified (signed) announcement.
+func (c *Client) handleAnnouncement(a discovery.Announcement) {
+	adopted := false
+	if a.URL != "" && a.Verified && len(c.cfg().Navigator.Endpoints()) == 0 {
+		if previous, _ := c.discoveredURL.Load().(string); previous != a.URL {
+			c.logger.Info("Adopting Navigator URL from discovery", "url", a.URL, "source", a.Source)
+			c.discoveredURL.Store(a.URL)
+			adopted = true
+		}
+	}
+
+	// A newly adopted URL always wakes the loop, mDNS reports it only once
+	now := time.Now()
+	last := time.Unix(0, c.lastWakeup.Load())
+	if !adopted && now.Sub(last) < minWakeupInterval {
+		c.logger.Debug("Ignoring announcement, wakeup rate limit", "source", a.Source)
+		return
+	}
+	c.lastWakeup.Store(now.UnixNano())
+
+	c.logger.Info("Navigator discovered, triggering reconnect", "source", a.Source, "verified", a.Verified)
+	signal(c.wakeup)
+}
+
+// navigatorEndpoints returns the configured endpoints, or the URL adopted
+// from discovery if none are configured
+func (c *Client) navigatorEndpoints() []string {
+	if urls := c.cfg().Navigator.Endpoints(); len(urls) > 0 {
+		return urls
+	}
+	if u, _ := c.discoveredURL.Load().(string); u != "" {
+		return []string{u}
+	}
+	return nil
+}
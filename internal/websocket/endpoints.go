@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// endpointSet tracks the configured Navigator endpoints, which of them
+// failed in the current connection round and which one is active
+type endpointSet struct {
+	mu      sync.Mutex
+	urls    []string
+	tried   []bool
+	cursor  int // Next start position for round-robin
+	active  int // Index of the connected endpoint, -1 if none
+	lastURL string
+}
+
+// sync adopts a (possibly changed) endpoint list
+func (e *endpointSet) sync(urls []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if slices.Equal(e.urls, urls) {
+		return
+	}
+	e.urls = slices.Clone(urls)
+	e.tried = make([]bool, len(urls))
+	e.cursor = 0
+	e.active = -1
+}
+
+// pick returns the next endpoint to try in this round. Priority mode
+// always starts with the first (preferred) endpoint, round-robin rotates
+// the start position. ok is false once every endpoint failed this round.
+func (e *endpointSet) pick(selection string) (index int, u string, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start := 0
+	if selection == "round-robin" {
+		start = e.cursor
+	}
+
+	for i := 0; i < len(e.urls); i++ {
+		idx := (start + i) % len(e.urls)
+		if !e.tried[idx] {
+			e.lastURL = e.urls[idx]
+			return idx, e.urls[idx], true
+		}
+	}
+	return -1, "", false
+}
+
+// failed marks an endpoint as failed for the current round
+func (e *endpointSet) failed(index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if index >= 0 && index < len(e.tried) {
+		e.tried[index] = true
+	}
+}
+
+// connected records the active endpoint and starts a fresh round
+func (e *endpointSet) connected(index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.active = index
+	e.cursor = (index + 1) % max(len(e.urls), 1)
+	clear(e.tried)
+}
+
+// disconnected clears the active endpoint
+func (e *endpointSet) disconnected() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.active = -1
+}
+
+// newRound allows all endpoints to be tried again
+func (e *endpointSet) newRound() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clear(e.tried)
+}
+
+// activeURL returns the connected endpoint, or "" if not connected
+func (e *endpointSet) activeURL() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.active < 0 || e.active >= len(e.urls) {
+		return ""
+	}
+	return e.urls[e.active]
+}
+
+// current returns the endpoint of the running or last connection attempt
+func (e *endpointSet) current() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastURL
+}
+
+// onPreferred reports whether the active endpoint is the first one
+func (e *endpointSet) onPreferred() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.active <= 0
+}
+
+// preferred returns the highest priority endpoint
+func (e *endpointSet) preferred() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.urls) == 0 {
+		return ""
+	}
+	return e.urls[0]
+}
+
+// probeEndpoint checks whether the endpoint accepts TCP connections.
+// It does not open a WebSocket session, so the Navigator sees no
+// half-registered mate.
+func probeEndpoint(rawURL string, timeout time.Duration) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" || u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// watchFailback periodically probes the preferred endpoint while the
+// client is connected to a fallback and reconnects once it is back
+func (c *Client) watchFailback(connDone chan struct{}) {
+	for {
+		interval := c.cfg().Navigator.FailbackInterval
+		if interval <= 0 || c.cfg().Navigator.Selection == "round-robin" || c.endpoints.onPreferred() {
+			return
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-connDone:
+			return
+		case <-time.After(interval):
+		}
+
+		preferred := c.endpoints.preferred()
+		if probeEndpoint(preferred, 5*time.Second) {
+			c.logger.Info("Preferred Navigator endpoint is reachable again, failing back", "url", preferred)
+			signal(c.reconnect)
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/gorilla/websocket"
+	"github.com/javafleet/fleet-mate-linux/internal/commands"
+	"github.com/javafleet/fleet-mate-linux/internal/config"
+	"github.com/javafleet/fleet-mate-linux/internal/pathacl"
+)
+
+// accessList builds a path allowlist. Besides the configured deny entries,
+// the built-in sensitive files and the mate's own state, credentials,
+// audit journal (with its rotated files) and policy are always denied.
+func accessList(cfg *config.Config, allow, deny []string) (*pathacl.List, error) {
+	all := append(slices.Clone(pathacl.Sensitive), deny...)
+	rotated := ""
+	if cfg.Audit.File != "" {
+		rotated = cfg.Audit.File + ".*"
+	}
+	for _, p := range []string{cfg.Mate.StateDir, cfg.Navigator.TLS.KeyFile, cfg.Audit.File, rotated, cfg.Commands.PolicyFile} {
+		if p == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			all = append(all, abs)
+		}
+	}
+	return pathacl.New(allow, all)
+}
+
+// fileTransfer creates a transfer handler for the current configuration
+func (c *Client) fileTransfer() (*commands.FileTransfer, error) {
+	cfg := c.cfg()
+	access, err := accessList(cfg, cfg.Files.Allow, cfg.Files.Deny)
+	if err != nil {
+		return nil, err
+	}
+	ft := commands.NewFileTransfer(cfg.Mate.ID, access, c.audit)
+	ft.MaxSize = int64(cfg.Files.MaxSize) * 1024 * 1024
+	return ft, nil
+}
+
+// handleFileDownload sends a file to the Navigator
+func (c *Client) handleFileDownload(payload map[string]interface{}) {
+	request := commands.FileDownloadRequest{
+		SessionID: getStringFromPayload(payload, "sessionId", ""),
+		Path:      getStringFromPayload(payload, "path", ""),
+		Offset:    int64(getIntFromPayload(payload, "offset", 0)),
+		ChunkSize: getIntFromPayload(payload, "chunkSize", 0),
+		Encoding:  getStringFromPayload(payload, "encoding", commands.EncodingBase64),
+	}
+
+	ft, err := c.fileTransfer()
+	if err != nil {
+		c.rejectSession("file_download", request.SessionID, err)
+		return
+	}
+
+	c.startSession("file_download", request.SessionID, sessionFile, func(ctx context.Context) {
+		if err := ft.HandleDownload(ctx, request, c.sendData, c.sendBinary); err != nil {
+			c.logger.Error("Failed to download file", "error", err)
+		}
+	})
+}
+
+// handleFileUpload prepares an upload, the chunks follow as file_chunk
+// messages or binary frames
+func (c *Client) handleFileUpload(payload map[string]interface{}) {
+	request := commands.FileUploadRequest{
+		SessionID: getStringFromPayload(payload, "sessionId", ""),
+		Path:      getStringFromPayload(payload, "path", ""),
+		Size:      int64(getIntFromPayload(payload, "size", -1)),
+		SHA256:    getStringFromPayload(payload, "sha256", ""),
+		Offset:    int64(getIntFromPayload(payload, "offset", 0)),
+		Mode:      getStringFromPayload(payload, "mode", ""),
+	}
+
+	// Chunks are routed by session ID, an upload can't run without one
+	if request.SessionID == "" {
+		c.rejectSession("file_upload", "", errors.New("sessionId is required"))
+		return
+	}
+
+	ft, err := c.fileTransfer()
+	if err != nil {
+		c.rejectSession("file_upload", request.SessionID, err)
+		return
+	}
+
+	ctx, finish, ok := c.startInteractive("file_upload", request.SessionID, sessionUpload)
+	if !ok {
+		return
+	}
+
+	upload, err := ft.OpenUpload(ctx, request, c.sendData)
+	if err != nil {
+		finish()
+		c.logger.Error("Failed to start upload", "error", err)
+		return
+	}
+
+	c.uploads.add(request.SessionID, upload)
+	go func() {
+		<-upload.Done()
+		c.uploads.remove(request.SessionID)
+		finish()
+	}()
+}
+
+// handleFileChunk writes a base64 encoded upload chunk
+func (c *Client) handleFileChunk(payload map[string]interface{}) {
+	sessionID := getStringFromPayload(payload, "sessionId", "")
+	data, err := commands.DecodeChunk(getStringFromPayload(payload, "data", ""))
+	if err != nil {
+		c.rejectSession("file_chunk", sessionID, err)
+		return
+	}
+	c.writeChunk(sessionID, int64(getIntFromPayload(payload, "offset", -1)), data)
+}
+
+// handleBinaryFrame writes an upload chunk sent as binary frame
+func (c *Client) handleBinaryFrame(frame []byte) {
+	if !c.authenticated.Load() {
+		c.rejectUnauthenticated(Command{Type: "file_chunk"})
+		return
+	}
+
+	header, data, err := commands.DecodeFrame(frame)
+	if err != nil {
+		c.logger.Warn("Ignoring invalid binary frame", "error", err)
+		return
+	}
+	if header.Type != "file_chunk" {
+		c.logger.Warn("Ignoring binary frame of unknown type", "type", header.Type)
+		return
+	}
+	c.writeChunk(header.SessionID, header.Offset, data)
+}
+
+// writeChunk passes a chunk to the running upload
+func (c *Client) writeChunk(sessionID string, offset int64, data []byte) {
+	upload, ok := c.uploads.get(sessionID)
+	if !ok {
+		c.rejectSession("file_chunk", sessionID, fmt.Errorf("no upload session %s", sessionID))
+		return
+	}
+	if err := upload.WriteChunk(offset, data); err != nil {
+		c.rejectSession("file_chunk", sessionID, err)
+	}
+}
+
+// sendBinary sends a binary frame, used for file chunks
+func (c *Client) sendBinary(frame []byte) error {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		return fmt.Errorf("failed to send frame: %w", err)
+	}
+	return nil
+}
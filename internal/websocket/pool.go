@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// workerPool bounds the number of concurrently running sessions. Sessions
+// beyond the limit wait in a FIFO queue; when the queue is full as well,
+// they are rejected as busy. Limits are passed on every call so a config
+// reload takes effect for the next session.
+type workerPool struct {
+	mu      sync.Mutex
+	running int
+	waiting []chan struct{}
+}
+
+// busyError is returned when neither a slot nor a queue place is free
+type busyError struct {
+	running, queued int
+}
+
+func (e *busyError) Error() string {
+	return fmt.Sprintf("mate is busy: %d sessions running, %d queued", e.running, e.queued)
+}
+
+// enqueue reserves a slot. The returned channel is closed once the session
+// may run; position is its place in the queue (0 = runs immediately).
+func (p *workerPool) enqueue(maxRunning, maxQueued int) (<-chan struct{}, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ready := make(chan struct{})
+	if p.running < maxRunning && len(p.waiting) == 0 {
+		p.running++
+		close(ready)
+		return ready, 0, nil
+	}
+	if len(p.waiting) >= maxQueued {
+		return nil, 0, &busyError{running: p.running, queued: len(p.waiting)}
+	}
+	p.waiting = append(p.waiting, ready)
+	return ready, len(p.waiting), nil
+}
+
+// wait blocks until the slot is granted or ctx is done. It reports
+// whether the caller holds a slot and must call release.
+func (p *workerPool) wait(ctx context.Context, ready <-chan struct{}) bool {
+	select {
+	case <-ready:
+		return true
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, ch := range p.waiting {
+		if ch == ready {
+			p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+			return false
+		}
+	}
+	// Granted while ctx was cancelled
+	return true
+}
+
+// release frees a slot and hands free slots to the oldest waiting sessions
+func (p *workerPool) release(maxRunning int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running--
+	for p.running < maxRunning && len(p.waiting) > 0 {
+		close(p.waiting[0])
+		p.waiting = p.waiting[1:]
+		p.running++
+	}
+}
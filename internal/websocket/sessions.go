@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Session kinds
+const (
+	sessionCommand = "command"
+	sessionLog     = "log"
+	sessionFollow  = "follow" // read_log in follow mode
+	sessionShell   = "shell"
+	sessionFile    = "file" // file_download
+	sessionUpload  = "upload"
+)
+
+// session is a running command or log transfer
+type session struct {
+	kind    string
+	cancel  context.CancelFunc
+	started time.Time
+}
+
+// sessionRegistry tracks running sessions by the Navigator's session ID so
+// they can be cancelled with cancel_session
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// start registers a session and returns its context and a function that
+// must be called when the session is over. Sessions without ID run
+// unregistered and cannot be cancelled.
+func (r *sessionRegistry) start(id, kind string) (context.Context, func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if id == "" {
+		return ctx, cancel, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions == nil {
+		r.sessions = make(map[string]*session)
+	}
+	if _, exists := r.sessions[id]; exists {
+		cancel()
+		return nil, nil, fmt.Errorf("session %s is already running", id)
+	}
+
+	s := &session{kind: kind, cancel: cancel, started: time.Now()}
+	r.sessions[id] = s
+
+	finish := func() {
+		cancel()
+		r.mu.Lock()
+		if r.sessions[id] == s {
+			delete(r.sessions, id)
+		}
+		r.mu.Unlock()
+	}
+	return ctx, finish, nil
+}
+
+// cancel stops the session with the given ID
+func (r *sessionRegistry) cancel(id string) (session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok {
+		return session{}, false
+	}
+	s.cancel()
+	return *s, true
+}
+
+// cancelAll stops every running session (used on shutdown)
+func (r *sessionRegistry) cancelAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		s.cancel()
+	}
+}
+
+// cancelKind stops every running session of one kind
+func (r *sessionRegistry) cancelKind(kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.kind == kind {
+			s.cancel()
+		}
+	}
+}
+
+// liveRegistry keeps the handles of sessions that receive further
+// commands after they started (shell input, upload chunks)
+type liveRegistry[T any] struct {
+	mu    sync.Mutex
+	items map[string]T
+}
+
+// add registers a handle until remove is called
+func (r *liveRegistry[T]) add(id string, v T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.items == nil {
+		r.items = make(map[string]T)
+	}
+	r.items[id] = v
+}
+
+// remove unregisters a handle
+func (r *liveRegistry[T]) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.items, id)
+}
+
+// get returns the handle of a session
+func (r *liveRegistry[T]) get(id string) (T, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.items[id]
+	return v, ok
+}
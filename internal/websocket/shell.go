@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/javafleet/fleet-mate-linux/internal/commands"
+)
+
+// handleShellOpen starts an interactive shell
+func (c *Client) handleShellOpen(payload map[string]interface{}) {
+	request := commands.ShellOpenRequest{
+		SessionID:  getStringFromPayload(payload, "sessionId", ""),
+		Shell:      getStringFromPayload(payload, "shell", ""),
+		User:       getStringFromPayload(payload, "user", ""),
+		WorkingDir: getStringFromPayload(payload, "workingDir", ""),
+		Cols:       getIntFromPayload(payload, "cols", 0),
+		Rows:       getIntFromPayload(payload, "rows", 0),
+		Term:       getStringFromPayload(payload, "term", ""),
+	}
+
+	// Input is routed by session ID, a shell can't run without one
+	if request.SessionID == "" {
+		c.rejectSession("shell_open", "", errors.New("sessionId is required"))
+		return
+	}
+
+	ctx, finish, ok := c.startInteractive("shell_open", request.SessionID, sessionShell)
+	if !ok {
+		return
+	}
+
+	executor := commands.NewCommandExecutor(c.cfg().Mate.ID, c.cfg().Commands.Policy, c.audit)
+	sh, err := executor.OpenShell(ctx, request, c.sendData)
+	if err != nil {
+		finish()
+		c.logger.Error("Failed to open shell", "error", err)
+		return
+	}
+
+	c.shells.add(request.SessionID, sh)
+	go func() {
+		<-sh.Done()
+		c.shells.remove(request.SessionID)
+		finish()
+	}()
+}
+
+// handleShellInput writes base64 encoded input to a shell
+func (c *Client) handleShellInput(payload map[string]interface{}) {
+	sessionID := getStringFromPayload(payload, "sessionId", "")
+
+	sh, ok := c.shells.get(sessionID)
+	if !ok {
+		c.rejectSession("shell_input", sessionID, fmt.Errorf("no shell session %s", sessionID))
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(getStringFromPayload(payload, "data", ""))
+	if err != nil {
+		c.rejectSession("shell_input", sessionID, fmt.Errorf("invalid base64 input: %w", err))
+		return
+	}
+	if err := sh.Write(data); err != nil {
+		c.rejectSession("shell_input", sessionID, err)
+	}
+}
+
+// handleShellResize changes the terminal size of a shell
+func (c *Client) handleShellResize(payload map[string]interface{}) {
+	sessionID := getStringFromPayload(payload, "sessionId", "")
+
+	sh, ok := c.shells.get(sessionID)
+	if !ok {
+		c.rejectSession("shell_resize", sessionID, fmt.Errorf("no shell session %s", sessionID))
+		return
+	}
+	if err := sh.Resize(getIntFromPayload(payload, "cols", 0), getIntFromPayload(payload, "rows", 0)); err != nil {
+		c.rejectSession("shell_resize", sessionID, err)
+	}
+}
+
+// handleShellClose hangs up a shell, it reports shell_exit when it is gone
+func (c *Client) handleShellClose(payload map[string]interface{}) {
+	sessionID := getStringFromPayload(payload, "sessionId", "")
+
+	sh, ok := c.shells.get(sessionID)
+	if !ok {
+		c.rejectSession("shell_close", sessionID, fmt.Errorf("no shell session %s", sessionID))
+		return
+	}
+	c.logger.Info("Closing shell", "session", sessionID)
+	sh.Close()
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import "time"
+
+// State is the connection lifecycle state of the client
+type State int
+
+const (
+	StateIdle       State = iota // Run not started yet
+	StateConnecting              // Dialing, authenticating and registering
+	StateRegistered              // Connected and registered, streaming stats
+	StateBackoff                 // Waiting before the next connection attempt
+	StateListening               // Waiting for a discovery signal from the Navigator
+	StateStopping                // Stop requested, tearing down
+	StateStopped                 // Run has returned
+)
+
+// String returns the state name used in logs and status messages
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateConnecting:
+		return "connecting"
+	case StateRegistered:
+		return "registered"
+	case StateBackoff:
+		return "backoff"
+	case StateListening:
+		return "listening"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the current connection state and since when it is active
+func (c *Client) State() (State, time.Time) {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.state, c.stateSince
+}
+
+// setState records a state transition. Once stopping, only stopped may follow.
+func (c *Client) setState(s State) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	if c.state == s || (c.state >= StateStopping && s < c.state) {
+		return
+	}
+
+	c.logger.Debug("Connection state changed", "from", c.state.String(), "to", s.String())
+	c.state = s
+	c.stateSince = time.Now()
+}
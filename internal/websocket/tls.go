@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/javafleet/fleet-mate-linux/internal/config"
+)
+
+// certReloader caches the client key pair and reloads it when the files
+// on disk change, so rotated certificates are used on the next handshake
+type certReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	certMod  time.Time
+	keyMod   time.Time
+	cert     *tls.Certificate
+}
+
+// get returns the current key pair, reloading it if necessary
+func (r *certReloader) get(certFile, keyFile string) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat client certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat client key: %w", err)
+	}
+
+	if r.cert != nil && r.certFile == certFile && r.keyFile == keyFile &&
+		r.certMod.Equal(certInfo.ModTime()) && r.keyMod.Equal(keyInfo.ModTime()) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.certFile = certFile
+	r.keyFile = keyFile
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return r.cert, nil
+}
+
+// buildTLSConfig creates the TLS configuration for a new connection.
+// It returns nil when no TLS options are set, leaving the dialer defaults
+// (system CAs) in place.
+func buildTLSConfig(tc config.TLSConfig, certs *certReloader) (*tls.Config, error) {
+	if tc.CAFile == "" && tc.CertFile == "" && tc.ServerName == "" && len(tc.PinnedKeys) == 0 {
+		return nil, nil
+	}
+
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: tc.ServerName,
+	}
+
+	// Custom CA bundle (read on every connect so CA rotation needs no restart)
+	if tc.CAFile != "" {
+		pem, err := os.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", tc.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	// Client certificate for mutual TLS
+	if tc.CertFile != "" {
+		// Fail early instead of during the handshake
+		if _, err := certs.get(tc.CertFile, tc.KeyFile); err != nil {
+			return nil, err
+		}
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certs.get(tc.CertFile, tc.KeyFile)
+		}
+	}
+
+	// Public key pinning on top of the normal chain verification
+	if len(tc.PinnedKeys) > 0 {
+		var pins [][]byte
+		for _, pin := range tc.PinnedKeys {
+			raw, err := config.ParsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, raw)
+		}
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs.PeerCertificates, pins)
+		}
+	}
+
+	return conf, nil
+}
+
+// verifyPins accepts the connection if any certificate in the chain
+// presented by the server matches one of the pinned SPKI hashes
+func verifyPins(chain []*x509.Certificate, pins [][]byte) error {
+	for _, cert := range chain {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("server certificate does not match any pinned key")
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/javafleet/fleet-mate-linux/internal/config"
 	"github.com/javafleet/fleet-mate-linux/internal/hardware"
+	"github.com/javafleet/fleet-mate-linux/internal/identity"
+	"github.com/javafleet/fleet-mate-linux/internal/logging"
+	"github.com/javafleet/fleet-mate-linux/internal/policy"
+	"github.com/javafleet/fleet-mate-linux/internal/sandbox"
 	"github.com/javafleet/fleet-mate-linux/internal/websocket"
 )
 
@@ -16,12 +23,43 @@ const (
 	version = "1.1.0"
 )
 
+// stringList collects repeated command line flags
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func main() {
+	// Re-exec by the command executor to apply resource limits
+	if sandbox.IsHelper() {
+		err := sandbox.RunHelper()
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(127)
+	}
+
 	// Command line flags
 	configFile := flag.String("config", "config.yml", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version information")
+	watchInterval := flag.Duration("watch", 0, "Poll the configuration file for changes at this interval (0 = only reload on SIGHUP)")
+	var overrides stringList
+	flag.Var(&overrides, "set", "Override a config key, e.g. -set navigator.url=ws://host:2025/api/fleet-mate/ws (repeatable)")
 	flag.Parse()
 
+	// Without an explicit -config a missing config.yml is fine: defaults,
+	// FLEET_MATE_* variables and -set overrides are enough to start
+	configExplicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configExplicit = true
+		}
+	})
+
 	if *showVersion {
 		log.Printf("Fleet Mate Linux v%s", version)
 		os.Exit(0)
@@ -30,41 +68,182 @@ func main() {
 	log.Printf("Fleet Mate Linux v%s starting...", version)
 
 	// Load configuration
-	cfg, err := config.Load(*configFile)
+	loader := config.Loader{
+		File:         *configFile,
+		FileOptional: !configExplicit,
+		Environ:      os.Environ(),
+		Overrides:    overrides,
+	}
+	cfg, err := loader.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	log.Printf("Configuration loaded from %s", *configFile)
-	log.Printf("Mate ID: %s", cfg.Mate.ID)
-	log.Printf("Mate Name: %s", cfg.Mate.Name)
-	log.Printf("Navigator URL: %s", cfg.Navigator.URL)
-	log.Printf("Monitoring interval: %s", cfg.Monitoring.Interval)
+	// Set up logging before any component grabs its logger
+	logCloser, err := logging.Setup(cfg.Logging)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logCloser.Close()
+
+	logger := logging.For("main")
+
+	// Resolve the mate ID (configured, persisted or generated)
+	if err := resolveIdentity(cfg); err != nil {
+		log.Fatalf("Failed to determine mate identity: %v", err)
+	}
+
+	// Load the command policy
+	if err := resolvePolicy(cfg); err != nil {
+		log.Fatalf("Failed to load command policy: %v", err)
+	}
+
+	logger.Info("Configuration loaded",
+		"file", *configFile,
+		"mate_id", cfg.Mate.ID,
+		"mate_id_source", cfg.Mate.IDSource,
+		"mate_name", cfg.Mate.Name,
+		"navigator_url", cfg.Navigator.URL,
+		"interval", cfg.Monitoring.Interval,
+		"log_level", cfg.Logging.Level,
+		"command_policy", cfg.Commands.Policy.Source)
 
 	// Create hardware monitor
 	monitor := hardware.NewMonitor(cfg)
-	log.Println("Hardware monitor initialized")
+	logger.Debug("Hardware monitor initialized")
 
 	// Create WebSocket client
 	client := websocket.NewClient(cfg, monitor)
-	log.Println("WebSocket client initialized")
+	logger.Debug("WebSocket client initialized")
 
-	// Handle shutdown signals
+	// Handle shutdown and reload signals
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Optional config file watcher
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	var configChanged <-chan struct{}
+	if *watchInterval > 0 {
+		configChanged = config.Watch(*configFile, *watchInterval, stopWatch)
+		logger.Info("Watching configuration file for changes", "file", *configFile, "interval", *watchInterval)
+	}
 
 	// Start client in background
+	runDone := make(chan struct{})
 	go func() {
+		defer close(runDone)
 		if err := client.Run(); err != nil {
-			log.Printf("Client error: %v", err)
+			logger.Error("Client error", "error", err)
 		}
 	}()
 
-	// Wait for shutdown signal
-	sig := <-sigChan
-	log.Printf("Received signal %v, shutting down...", sig)
+	// Wait for shutdown signal, reloading the configuration on SIGHUP
+	for running := true; running; {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				logger.Info("Received SIGHUP, reloading configuration")
+				cfg = reloadConfig(loader, cfg, monitor, client)
+				continue
+			}
+			logger.Info("Received signal, shutting down", "signal", sig.String())
+			running = false
+		case <-configChanged:
+			logger.Info("Configuration file changed, reloading")
+			cfg = reloadConfig(loader, cfg, monitor, client)
+		case <-runDone:
+			// Client stopped itself, e.g. on a shutdown command
+			running = false
+		}
+	}
 
 	// Graceful shutdown
 	client.Stop()
-	log.Println("Fleet Mate stopped")
+	select {
+	case <-runDone:
+	case <-time.After(5 * time.Second):
+		logger.Warn("Client did not stop within 5 seconds")
+	}
+	logger.Info("Fleet Mate stopped")
+}
+
+// reloadConfig re-reads and validates the configuration file and hands it to
+// the running components. An invalid file is rejected and the current
+// configuration stays active. Environment and -set overrides still apply.
+func reloadConfig(loader config.Loader, current *config.Config, monitor *hardware.Monitor, client *websocket.Client) *config.Config {
+	logger := logging.For("main")
+
+	loader.Environ = os.Environ()
+	cfg, err := loader.Load()
+	if err != nil {
+		logger.Error("Configuration reload rejected, keeping current configuration", "error", err)
+		return current
+	}
+
+	if err := resolveIdentity(cfg); err != nil {
+		logger.Error("Configuration reload rejected, keeping current configuration", "error", err)
+		return current
+	}
+
+	if err := resolvePolicy(cfg); err != nil {
+		logger.Error("Configuration reload rejected, keeping current configuration", "error", err)
+		return current
+	}
+
+	lvl, err := logging.ParseLevel(cfg.Logging.Level)
+	if err != nil {
+		logger.Error("Configuration reload rejected, keeping current configuration", "error", err)
+		return current
+	}
+	logging.SetLevel(lvl)
+
+	if cfg.Logging.File != current.Logging.File || cfg.Logging.Format != current.Logging.Format {
+		logger.Warn("Changes to logging.file and logging.format take effect after a restart")
+	}
+
+	monitor.SetConfig(cfg)
+	client.UpdateConfig(cfg)
+
+	logger.Info("Configuration reloaded",
+		"file", loader.File,
+		"interval", cfg.Monitoring.Interval,
+		"log_level", cfg.Logging.Level,
+		"command_policy", cfg.Commands.Policy.Source)
+	return cfg
+}
+
+// resolveIdentity fills in cfg.Mate.ID and cfg.Mate.IDSource
+func resolveIdentity(cfg *config.Config) error {
+	logger := logging.For("main")
+
+	id, err := identity.Resolve(cfg.Mate.StateDir, cfg.Mate.ID)
+	if id.ID == "" {
+		return err
+	}
+	if err != nil {
+		logger.Warn("Mate identity could not be persisted", "state_dir", cfg.Mate.StateDir, "error", err)
+	}
+	if id.Source == identity.SourceRecovered {
+		logger.Warn("identity.json was corrupt, kept it as identity.json.corrupt and derived the mate ID again",
+			"state_dir", cfg.Mate.StateDir, "mate_id", id.ID)
+	}
+	if id.Source == identity.SourceRegenerated {
+		logger.Warn("machine-id changed since the identity was created, assuming a cloned image and generating a new mate ID",
+			"mate_id", id.ID)
+	}
+
+	cfg.Mate.ID = id.ID
+	cfg.Mate.IDSource = id.Source
+	return nil
+}
+
+// resolvePolicy loads commands.policy_file into cfg.Commands.Policy
+func resolvePolicy(cfg *config.Config) error {
+	p, err := policy.Load(cfg.Commands.PolicyFile)
+	if err != nil {
+		return err
+	}
+	cfg.Commands.Policy = p
+	return nil
 }